@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go-twitch/config"
+	"go-twitch/twitch"
+)
+
+const usage = `usage:
+  go-twitch auth device [-role bot|broadcaster|moderator] [-scopes feature,scope]
+  go-twitch tokens genkey
+  go-twitch tokens rekey
+  go-twitch eventsub send [-url callback] [-type message-type] [-id message-id] [-timestamp RFC3339] fixture.json`
+
+// runCommand runs a CLI subcommand if args name one and reports whether it
+// did. Without a subcommand the server starts as usual.
+func runCommand(cfg config.Config, args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	switch args[0] + " " + args[1] {
+	case "auth device":
+		authDevice(cfg, args[2:])
+	case "tokens genkey":
+		tokensGenkey()
+	case "tokens rekey":
+		tokensRekey(cfg)
+	case "eventsub send":
+		eventSubSend(cfg, args[2:])
+	default:
+		if args[0] != "auth" && args[0] != "tokens" && args[0] != "eventsub" {
+			return false
+		}
+		fmt.Fprintf(os.Stderr, "unknown command: %s %s\n%s\n", args[0], args[1], usage)
+		os.Exit(2)
+	}
+	return true
+}
+
+// authDevice authorizes the user token with the Device Code Grant and stores
+// it like the OAuth callback does.
+func authDevice(cfg config.Config, args []string) {
+	fs := flag.NewFlagSet("auth device", flag.ExitOnError)
+	extra := fs.String("scopes", "", "comma separated feature names or scopes to request in addition to the configured ones")
+	roleName := fs.String("role", "broadcaster", "what the account is used for: bot, broadcaster or moderator")
+	fs.Parse(args)
+	role, err := twitch.ParseRole(*roleName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	accounts, err := twitch.NewAccounts(ctx, cfg, openTokenStore(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	scopes := cfg.RequestScopes(nil, strings.Split(*extra, ","))
+
+	d, err := twitch.StartDeviceAuth(ctx, cfg, scopes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Open %s and enter the code: %s\n", d.VerificationURI, d.UserCode)
+	fmt.Println("Waiting for approval...")
+
+	token, err := twitch.WaitDeviceToken(ctx, cfg, d)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := accounts.Add(ctx, token, role)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Authorized %s as %s. User token stored, expires at %s\n", m.Current().Login, role, token.ExpiresAt.Format("2006-01-02 15:04:05 MST"))
+}
+
+// tokensGenkey prints a new random token encryption key.
+func tokensGenkey() {
+	key, err := twitch.GenerateTokenKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(key)
+}
+
+// tokensRekey re-encrypts every stored token with the current key. Run it
+// after rotating TOKEN_ENCRYPTION_KEY (keeping the previous key in
+// TOKEN_ENCRYPTION_OLD_KEYS), or after enabling encryption on a plaintext
+// store; the old key can be dropped once it has succeeded.
+func tokensRekey(cfg config.Config) {
+	store, err := twitch.OpenTokenStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	enc, ok := store.(*twitch.EncryptedStore)
+	if !ok {
+		log.Fatal("token encryption is not configured; set TOKEN_ENCRYPTION_KEY or TOKEN_ENCRYPTION_KEY_FILE")
+	}
+	n, err := enc.Rekey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Re-encrypted %d token(s) with the current key.\n", n)
+}
+
+// eventSubSend posts a fixture to the EventSub webhook callback, signed with
+// EVENTSUB_WEBHOOK_SECRET like Twitch signs its requests. A fixed -id sends a
+// replay, an old -timestamp a stale message.
+func eventSubSend(cfg config.Config, args []string) {
+	fs := flag.NewFlagSet("eventsub send", flag.ExitOnError)
+	callback := fs.String("url", "http://localhost:"+cfg.Port+"/eventsub/callback", "callback URL")
+	msgType := fs.String("type", twitch.EventSubNotification, "message type: notification, webhook_callback_verification or revocation")
+	id := fs.String("id", "", "message ID (random if empty)")
+	timestamp := fs.String("timestamp", "", "message timestamp (now if empty)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal(usage)
+	}
+	if cfg.EventSubWebhookSecret == "" {
+		log.Fatal("EVENTSUB_WEBHOOK_SECRET is not set")
+	}
+	body, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var payload twitch.EventSubPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		log.Fatalf("invalid fixture: %v", err)
+	}
+	if *id == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		*id = hex.EncodeToString(b)
+	}
+	if *timestamp == "" {
+		*timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *callback, bytes.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(twitch.EventSubHeaderMessageID, *id)
+	req.Header.Set(twitch.EventSubHeaderMessageType, *msgType)
+	req.Header.Set(twitch.EventSubHeaderTimestamp, *timestamp)
+	req.Header.Set(twitch.EventSubHeaderSignature, twitch.SignEventSub(cfg.EventSubWebhookSecret, *id, *timestamp, body))
+	if sub := payload.Subscription; sub != nil {
+		req.Header.Set(twitch.EventSubHeaderSubscriptionType, sub.Type)
+		req.Header.Set(twitch.EventSubHeaderSubscriptionVersion, sub.Version)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	res, _ := io.ReadAll(resp.Body)
+	fmt.Printf("%s (message %s)\n%s\n", resp.Status, *id, res)
+}
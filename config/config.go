@@ -3,6 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,8 +17,66 @@ type Config struct {
 	ClientSecret string
 	RedirectURI  string
 	BotUsername  string
-	UserToken    string
-	AppToken     string
+	// ChannelAccounts maps a channel to the login of the account that acts
+	// as bot and moderator there (TWITCH_CHANNEL_ACCOUNTS, channel=login,...).
+	ChannelAccounts map[string]string
+	UserToken       string
+	AppToken        string
+	// Scopes are requested on every user authorization (TWITCH_SCOPES).
+	Scopes []string
+	// Features are the optional features whose scopes are requested by
+	// default (TWITCH_FEATURES, comma separated); FeatureScopes maps every
+	// known feature to the scopes it needs.
+	Features      []string
+	FeatureScopes map[string][]string
+
+	// SessionSecret signs the OAuth state; random per process when empty.
+	SessionSecret string
+	// HelixBaseURL overrides the Helix API root, e.g. to target a mock server.
+	HelixBaseURL string
+
+	// Retry policy for Helix requests answered with 429, 502 or 503.
+	HelixMaxRetries     int
+	HelixRetryBaseDelay time.Duration
+	HelixRetryMaxDelay  time.Duration
+
+	// ChatTransport is how chat messages are sent by default: irc, or helix
+	// to use the Send Chat Message API with IRC as fallback.
+	ChatTransport string
+	// ChatHelixAuth is the token Helix chat messages are sent with: user
+	// (the sending account's) or app (shows the chat bot badge).
+	ChatHelixAuth string
+
+	// EmoteProviders are the third-party emote providers resolved in chat
+	// messages (EMOTE_PROVIDERS: bttv, ffz, 7tv); their emote sets are
+	// cached for EmoteCacheTTL.
+	EmoteProviders []string
+	EmoteCacheTTL  time.Duration
+
+	// EventSubChannels are the channels whose EventSub events are received
+	// over the WebSocket transport (EVENTSUB_CHANNELS, comma separated), with
+	// the subscription types in EventSubTypes (EVENTSUB_TYPES).
+	EventSubChannels []string
+	EventSubTypes    []string
+	// EventSubWSURL overrides the EventSub WebSocket endpoint, e.g. to target
+	// the Twitch CLI's mock server.
+	EventSubWSURL string
+	// EventSubWebhookSecret verifies the signature of requests to the
+	// webhook callback. With EventSubWebhookCallback, the public URL of the
+	// callback, EventSubChannels are subscribed over webhooks instead of
+	// the WebSocket transport.
+	EventSubWebhookSecret   string
+	EventSubWebhookCallback string
+
+	// TokenStore selects where tokens are persisted: file, sqlite or memory.
+	// TokenStorePath is the file or database path for the first two.
+	TokenStore     string
+	TokenStorePath string
+	// Encryption keys for tokens at rest (base64, 32 bytes). Old keys are
+	// only used to read tokens written before a rotation.
+	TokenEncryptionKey     string
+	TokenEncryptionKeyFile string
+	TokenEncryptionOldKeys string
 }
 
 // Load reads environment variables (from .env if present) and returns Config.
@@ -26,13 +87,69 @@ func Load() Config {
 	}
 
 	cfg := Config{
-		Port:         getenvDefault("PORT", "3000"),
-		ClientID:     os.Getenv("TWITCH_CLIENT_ID"),
-		ClientSecret: os.Getenv("TWITCH_CLIENT_SECRET"),
-		RedirectURI:  os.Getenv("TWITCH_REDIRECT_URI"),
-		BotUsername:  os.Getenv("TWITCH_BOT_USERNAME"),
-		UserToken:    os.Getenv("TWITCH_USER_ACCESS_TOKEN"),
-		AppToken:     os.Getenv("TWITCH_APP_ACCESS_TOKEN"),
+		Port:          getenvDefault("PORT", "3000"),
+		ClientID:      os.Getenv("TWITCH_CLIENT_ID"),
+		ClientSecret:  os.Getenv("TWITCH_CLIENT_SECRET"),
+		RedirectURI:   os.Getenv("TWITCH_REDIRECT_URI"),
+		BotUsername:   os.Getenv("TWITCH_BOT_USERNAME"),
+		UserToken:     os.Getenv("TWITCH_USER_ACCESS_TOKEN"),
+		AppToken:      os.Getenv("TWITCH_APP_ACCESS_TOKEN"),
+		Scopes:        DefaultScopes,
+		FeatureScopes: loadFeatureScopes(),
+
+		SessionSecret: os.Getenv("SESSION_SECRET"),
+		HelixBaseURL:  getenvDefault("TWITCH_HELIX_BASE_URL", "https://api.twitch.tv/helix"),
+
+		HelixMaxRetries:     getenvInt("TWITCH_HELIX_MAX_RETRIES", 3),
+		HelixRetryBaseDelay: getenvDuration("TWITCH_HELIX_RETRY_BASE_DELAY", 500*time.Millisecond),
+		HelixRetryMaxDelay:  getenvDuration("TWITCH_HELIX_RETRY_MAX_DELAY", 10*time.Second),
+
+		ChatTransport: getenvDefault("TWITCH_CHAT_TRANSPORT", "irc"),
+		ChatHelixAuth: getenvDefault("TWITCH_CHAT_HELIX_AUTH", "user"),
+
+		EmoteCacheTTL: getenvDuration("EMOTE_CACHE_TTL", time.Hour),
+
+		EventSubWSURL:           getenvDefault("EVENTSUB_WS_URL", "wss://eventsub.wss.twitch.tv/ws"),
+		EventSubWebhookSecret:   os.Getenv("EVENTSUB_WEBHOOK_SECRET"),
+		EventSubWebhookCallback: os.Getenv("EVENTSUB_WEBHOOK_CALLBACK"),
+
+		TokenStore:     getenvDefault("TOKEN_STORE", "file"),
+		TokenStorePath: os.Getenv("TOKEN_STORE_PATH"),
+
+		TokenEncryptionKey:     os.Getenv("TOKEN_ENCRYPTION_KEY"),
+		TokenEncryptionKeyFile: os.Getenv("TOKEN_ENCRYPTION_KEY_FILE"),
+		TokenEncryptionOldKeys: os.Getenv("TOKEN_ENCRYPTION_OLD_KEYS"),
+	}
+	if v := os.Getenv("TWITCH_SCOPES"); v != "" {
+		cfg.Scopes = strings.Fields(v)
+	}
+	cfg.ChannelAccounts = map[string]string{}
+	for _, pair := range strings.Split(os.Getenv("TWITCH_CHANNEL_ACCOUNTS"), ",") {
+		channel, login, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		cfg.ChannelAccounts[strings.ToLower(strings.TrimSpace(channel))] = strings.ToLower(strings.TrimSpace(login))
+	}
+	for _, p := range strings.Split(os.Getenv("EMOTE_PROVIDERS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cfg.EmoteProviders = append(cfg.EmoteProviders, p)
+		}
+	}
+	for _, c := range strings.Split(os.Getenv("EVENTSUB_CHANNELS"), ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			cfg.EventSubChannels = append(cfg.EventSubChannels, strings.ToLower(c))
+		}
+	}
+	for _, t := range strings.Split(os.Getenv("EVENTSUB_TYPES"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			cfg.EventSubTypes = append(cfg.EventSubTypes, t)
+		}
+	}
+	for _, f := range strings.Split(os.Getenv("TWITCH_FEATURES"), ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			cfg.Features = append(cfg.Features, f)
+		}
 	}
 	return cfg
 }
@@ -44,3 +161,29 @@ func getenvDefault(key, def string) string {
 	}
 	return v
 }
+
+func getenvInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
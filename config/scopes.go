@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultScopes are requested on every user authorization.
+var DefaultScopes = []string{"chat:read", "chat:edit", "user:read:email"}
+
+// DefaultFeatureScopes lists the extra scopes each optional feature needs.
+// A feature's list can be replaced with TWITCH_FEATURE_<NAME>_SCOPES.
+var DefaultFeatureScopes = map[string][]string{
+	"moderation": {
+		"moderator:manage:banned_users",
+		"moderator:manage:chat_messages",
+		"moderator:manage:chat_settings",
+		"moderator:manage:announcements",
+		"moderator:read:chatters",
+	},
+	"channel_points": {
+		"channel:read:redemptions",
+		"channel:manage:redemptions",
+	},
+	"eventsub": {
+		"moderator:read:followers",
+		"channel:read:subscriptions",
+		"bits:read",
+		"channel:read:redemptions",
+		"moderator:read:chat_settings",
+	},
+	"followers": {
+		"moderator:read:followers",
+	},
+	"helix_chat": {
+		"user:write:chat",
+		"user:bot",
+		"channel:bot",
+	},
+}
+
+func loadFeatureScopes() map[string][]string {
+	features := make(map[string][]string, len(DefaultFeatureScopes))
+	for name, scopes := range DefaultFeatureScopes {
+		if v := os.Getenv("TWITCH_FEATURE_" + strings.ToUpper(name) + "_SCOPES"); v != "" {
+			scopes = strings.Fields(v)
+		}
+		features[name] = scopes
+	}
+	return features
+}
+
+// FeatureNames returns the configured feature names in sorted order.
+func (c Config) FeatureNames() []string {
+	names := make([]string, 0, len(c.FeatureScopes))
+	for name := range c.FeatureScopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RequestScopes returns the scopes to request for a new authorization: the
+// base scopes, the scopes of enabled features, everything already granted
+// (so consent is incremental rather than replacing what the token had) and
+// the extra items, each of which is either a feature name or a raw scope.
+func (c Config) RequestScopes(granted, extra []string) []string {
+	set := map[string]bool{}
+	add := func(scopes []string) {
+		for _, s := range scopes {
+			set[s] = true
+		}
+	}
+	add(c.Scopes)
+	add(granted)
+	for _, f := range c.Features {
+		add(c.FeatureScopes[f])
+	}
+	for _, item := range extra {
+		if scopes, ok := c.FeatureScopes[item]; ok {
+			add(scopes)
+		} else if item != "" {
+			add([]string{item})
+		}
+	}
+
+	scopes := make([]string, 0, len(set))
+	for s := range set {
+		scopes = append(scopes, s)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// MissingScopes returns the scopes feature needs that are not in granted.
+func (c Config) MissingScopes(feature string, granted []string) []string {
+	have := map[string]bool{}
+	for _, s := range granted {
+		have[s] = true
+	}
+	var missing []string
+	for _, s := range c.FeatureScopes[feature] {
+		if !have[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,178 @@
+// Package emotes splits chat messages into fragments and resolves the
+// third-party emotes (BTTV, FFZ, 7TV) usable in a channel. Emote sets come
+// from Providers and are cached per channel, so providers are only asked
+// again once the cache entry expires.
+package emotes
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long fetched emote sets are used before refetching.
+const DefaultTTL = time.Hour
+
+// fetchTimeout bounds a single provider fetch.
+const fetchTimeout = 10 * time.Second
+
+// Emote is an emote a provider makes available under Code.
+type Emote struct {
+	ID       string
+	Code     string
+	Provider string
+	URL      string
+}
+
+// Provider is a source of emote sets. Implementations return the global
+// emotes for an empty channelID. A channel without emotes at the provider
+// is no error and returns none.
+type Provider interface {
+	Name() string
+	Emotes(ctx context.Context, channelID string) ([]Emote, error)
+}
+
+// Cheermote is a cheer prefix and the minimum bits of each of its tiers.
+type Cheermote struct {
+	Prefix string
+	Tiers  []int
+}
+
+// CheermoteSource returns the cheermotes usable in a channel.
+type CheermoteSource func(ctx context.Context, channelID string) ([]Cheermote, error)
+
+// defaultCheermotes are used until a channel's cheermotes are known.
+var defaultCheermotes = []Cheermote{{Prefix: "cheer", Tiers: []int{1, 100, 1000, 5000, 10000}}}
+
+// Resolver finds the emotes and cheermotes of channels. Sets are fetched in
+// the background the first time a channel is seen and when they expire;
+// until then messages are split with what is known. A nil Resolver knows
+// Twitch emotes only. A Resolver is safe for concurrent use.
+type Resolver struct {
+	providers  []Provider
+	cheermotes CheermoteSource
+	ttl        time.Duration
+
+	mu   sync.Mutex
+	sets map[string]*emoteSet
+}
+
+type emoteSet struct {
+	emotes     map[string]Emote
+	cheermotes []Cheermote
+	fetched    time.Time
+	loading    bool
+}
+
+// NewResolver returns a Resolver asking providers for emotes and cheermotes
+// for cheermotes, either of which may be empty. Sets are cached for ttl, or
+// DefaultTTL if ttl <= 0.
+func NewResolver(providers []Provider, cheermotes CheermoteSource, ttl time.Duration) *Resolver {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Resolver{
+		providers:  providers,
+		cheermotes: cheermotes,
+		ttl:        ttl,
+		sets:       make(map[string]*emoteSet),
+	}
+}
+
+// lookup returns the third-party emote with code in channelID, falling back
+// to the global emotes.
+func (r *Resolver) lookup(channelID, code string) (Emote, bool) {
+	if r == nil || len(r.providers) == 0 {
+		return Emote{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range []string{channelID, ""} {
+		if set := r.set(id); set != nil {
+			if e, ok := set.emotes[code]; ok {
+				return e, true
+			}
+		}
+	}
+	return Emote{}, false
+}
+
+// cheermotesFor returns the cheermotes of channelID.
+func (r *Resolver) cheermotesFor(channelID string) []Cheermote {
+	if r == nil || r.cheermotes == nil || channelID == "" {
+		return defaultCheermotes
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if set := r.set(channelID); set != nil && set.cheermotes != nil {
+		return set.cheermotes
+	}
+	return defaultCheermotes
+}
+
+// set returns the cached set of channelID, starting a fetch if it is missing
+// or expired. It returns nil until the first fetch finished. Callers hold mu.
+func (r *Resolver) set(channelID string) *emoteSet {
+	set := r.sets[channelID]
+	if set == nil {
+		set = &emoteSet{}
+		r.sets[channelID] = set
+	}
+	if !set.loading && time.Since(set.fetched) > r.ttl {
+		set.loading = true
+		go r.fetch(channelID)
+	}
+	if set.fetched.IsZero() {
+		return nil
+	}
+	return set
+}
+
+// fetch loads the set of channelID from every provider. A provider that
+// fails keeps its previously fetched emotes.
+func (r *Resolver) fetch(channelID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	r.mu.Lock()
+	old := r.sets[channelID].emotes
+	r.mu.Unlock()
+
+	emotes := make(map[string]Emote)
+	for _, p := range r.providers {
+		list, err := p.Emotes(ctx, channelID)
+		if err != nil {
+			log.Printf("[EMOTES] %s emotes for %q: %v", p.Name(), channelID, err)
+			for code, e := range old {
+				if e.Provider == p.Name() {
+					emotes[code] = e
+				}
+			}
+			continue
+		}
+		for _, e := range list {
+			// Earlier providers win conflicting codes.
+			if _, taken := emotes[e.Code]; !taken {
+				emotes[e.Code] = e
+			}
+		}
+	}
+	var cheermotes []Cheermote
+	if r.cheermotes != nil && channelID != "" {
+		var err error
+		if cheermotes, err = r.cheermotes(ctx, channelID); err != nil {
+			log.Printf("[EMOTES] cheermotes for %q: %v", channelID, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	set := r.sets[channelID]
+	set.emotes = emotes
+	if cheermotes != nil {
+		set.cheermotes = cheermotes
+	}
+	set.fetched = time.Now()
+	set.loading = false
+}
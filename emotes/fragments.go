@@ -0,0 +1,183 @@
+package emotes
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go-twitch/events"
+)
+
+// twitchEmoteURL is the 1x image of a Twitch emote.
+const twitchEmoteURL = "https://static-cdn.jtvnw.net/emoticons/v2/%s/default/dark/1.0"
+
+var mentionRe = regexp.MustCompile(`^@([A-Za-z0-9_]+)`)
+
+// span is the range of a Twitch emote in a message, in runes, end inclusive.
+type span struct {
+	start, end int
+	id         string
+}
+
+// Fragments splits m into text, emote, cheermote, mention and URL
+// fragments. Twitch emotes come from m's emote positions, third-party
+// emotes and cheermotes from the sets known for m's channel.
+func (r *Resolver) Fragments(m events.ChatMessage) []events.Fragment {
+	runes := []rune(m.Message)
+	var spans []span
+	for _, e := range m.Emotes {
+		for _, p := range e.Positions {
+			if p.Start < 0 || p.End < p.Start || p.End >= len(runes) {
+				continue
+			}
+			spans = append(spans, span{start: p.Start, end: p.End, id: e.ID})
+		}
+	}
+	slices.SortFunc(spans, func(a, b span) int { return a.start - b.start })
+
+	f := fragmenter{r: r, channelID: m.ChannelID, bits: m.Bits}
+	pos := 0
+	for _, s := range spans {
+		if s.start < pos {
+			continue // overlapping positions
+		}
+		f.words(string(runes[pos:s.start]))
+		f.add(events.Fragment{
+			Type:  events.FragmentTypeEmote,
+			Text:  string(runes[s.start : s.end+1]),
+			Emote: &events.FragmentEmote{ID: s.id, Provider: "twitch", URL: fmt.Sprintf(twitchEmoteURL, s.id)},
+		})
+		pos = s.end + 1
+	}
+	f.words(string(runes[pos:]))
+	f.flush()
+	if f.out == nil {
+		return []events.Fragment{}
+	}
+	return f.out
+}
+
+// fragmenter collects the fragments of one message, merging adjacent text.
+type fragmenter struct {
+	r         *Resolver
+	channelID string
+	bits      int
+
+	out  []events.Fragment
+	text strings.Builder
+}
+
+func (f *fragmenter) flush() {
+	if f.text.Len() > 0 {
+		f.out = append(f.out, events.Fragment{Type: events.FragmentTypeText, Text: f.text.String()})
+		f.text.Reset()
+	}
+}
+
+func (f *fragmenter) add(frag events.Fragment) {
+	if frag.Type == events.FragmentTypeText {
+		f.text.WriteString(frag.Text)
+		return
+	}
+	f.flush()
+	f.out = append(f.out, frag)
+}
+
+// words classifies the space separated words of s.
+func (f *fragmenter) words(s string) {
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsSpace(r) })
+		if i != 0 {
+			if i < 0 {
+				i = len(s)
+			}
+			f.add(events.Fragment{Type: events.FragmentTypeText, Text: s[:i]})
+			s = s[i:]
+			continue
+		}
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end < 0 {
+			end = len(s)
+		}
+		f.word(s[:end])
+		s = s[end:]
+	}
+}
+
+func (f *fragmenter) word(w string) {
+	if e, ok := f.r.lookup(f.channelID, w); ok {
+		f.add(events.Fragment{
+			Type:  events.FragmentTypeEmote,
+			Text:  w,
+			Emote: &events.FragmentEmote{ID: e.ID, Provider: e.Provider, URL: e.URL},
+		})
+		return
+	}
+	if f.bits > 0 {
+		if c := f.cheermote(w); c != nil {
+			f.add(events.Fragment{Type: events.FragmentTypeCheermote, Text: w, Cheermote: c})
+			return
+		}
+	}
+	if m := mentionRe.FindStringSubmatch(w); m != nil {
+		f.add(events.Fragment{
+			Type:    events.FragmentTypeMention,
+			Text:    m[0],
+			Mention: &events.FragmentMention{UserLogin: strings.ToLower(m[1])},
+		})
+		f.add(events.Fragment{Type: events.FragmentTypeText, Text: w[len(m[0]):]})
+		return
+	}
+	if link := linkURL(w); link != "" {
+		f.add(events.Fragment{Type: events.FragmentTypeURL, Text: w, URL: link})
+		return
+	}
+	f.add(events.Fragment{Type: events.FragmentTypeText, Text: w})
+}
+
+// cheermote parses w as a cheer, e.g. Cheer100, of a known cheermote.
+func (f *fragmenter) cheermote(w string) *events.FragmentCheermote {
+	i := strings.LastIndexFunc(w, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 || i == len(w)-1 {
+		return nil
+	}
+	bits, err := strconv.Atoi(w[i+1:])
+	if err != nil || bits <= 0 {
+		return nil
+	}
+	prefix := w[:i+1]
+	for _, c := range f.r.cheermotesFor(f.channelID) {
+		if !strings.EqualFold(c.Prefix, prefix) {
+			continue
+		}
+		tier := 0
+		for _, t := range c.Tiers {
+			if t <= bits && t > tier {
+				tier = t
+			}
+		}
+		return &events.FragmentCheermote{Prefix: strings.ToLower(c.Prefix), Bits: bits, Tier: tier}
+	}
+	return nil
+}
+
+// linkURL returns the URL w links to, or empty if w is no link.
+func linkURL(w string) string {
+	lower := strings.ToLower(w)
+	switch {
+	case strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "https://"):
+	case strings.HasPrefix(lower, "www."):
+		w = "https://" + w
+	default:
+		return ""
+	}
+	u, err := url.Parse(w)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
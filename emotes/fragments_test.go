@@ -0,0 +1,183 @@
+package emotes
+
+import (
+	"reflect"
+	"testing"
+
+	"go-twitch/events"
+)
+
+const testChannelID = "1337"
+
+// newTestResolver returns a Resolver over providers whose sets of the
+// global and test channel are already fetched.
+func newTestResolver(providers ...Provider) *Resolver {
+	r := NewResolver(providers, nil, 0)
+	for _, id := range []string{"", testChannelID} {
+		r.sets[id] = &emoteSet{loading: true}
+		r.fetch(id)
+	}
+	return r
+}
+
+func twitchEmote(id string, positions ...events.EmotePosition) events.Emote {
+	return events.Emote{ID: id, Positions: positions}
+}
+
+func text(s string) events.Fragment {
+	return events.Fragment{Type: events.FragmentTypeText, Text: s}
+}
+
+func emote(code, id, provider, url string) events.Fragment {
+	return events.Fragment{Type: events.FragmentTypeEmote, Text: code, Emote: &events.FragmentEmote{ID: id, Provider: provider, URL: url}}
+}
+
+func kappa(code string) events.Fragment {
+	return emote(code, "25", "twitch", "https://static-cdn.jtvnw.net/emoticons/v2/25/default/dark/1.0")
+}
+
+func TestFragmentsTwitchEmotes(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		emotes  []events.Emote
+		want    []events.Fragment
+	}{
+		{
+			name:    "plain text",
+			message: "hello chat",
+			want:    []events.Fragment{text("hello chat")},
+		},
+		{
+			name:    "emote between text",
+			message: "hi Kappa there",
+			emotes:  []events.Emote{twitchEmote("25", events.EmotePosition{Start: 3, End: 7})},
+			want:    []events.Fragment{text("hi "), kappa("Kappa"), text(" there")},
+		},
+		{
+			// Positions count characters: in bytes Kappa starts at 5, in
+			// UTF-16 code units at 3.
+			name:    "positions after an emoji count characters",
+			message: "😀 Kappa",
+			emotes:  []events.Emote{twitchEmote("25", events.EmotePosition{Start: 2, End: 6})},
+			want:    []events.Fragment{text("😀 "), kappa("Kappa")},
+		},
+		{
+			name:    "positions after multi-byte text",
+			message: "héllo Kappa ünd",
+			emotes:  []events.Emote{twitchEmote("25", events.EmotePosition{Start: 6, End: 10})},
+			want:    []events.Fragment{text("héllo "), kappa("Kappa"), text(" ünd")},
+		},
+		{
+			name:    "repeated emote out of order",
+			message: "Kappa Kappa",
+			emotes:  []events.Emote{twitchEmote("25", events.EmotePosition{Start: 6, End: 10}, events.EmotePosition{Start: 0, End: 4})},
+			want:    []events.Fragment{kappa("Kappa"), text(" "), kappa("Kappa")},
+		},
+		{
+			name:    "overlapping ranges keep the first",
+			message: "Kappa Keepo",
+			emotes: []events.Emote{
+				twitchEmote("25", events.EmotePosition{Start: 0, End: 4}),
+				twitchEmote("1902", events.EmotePosition{Start: 3, End: 10}),
+			},
+			want: []events.Fragment{kappa("Kappa"), text(" Keepo")},
+		},
+		{
+			name:    "out of range positions are ignored",
+			message: "Kappa",
+			emotes:  []events.Emote{twitchEmote("25", events.EmotePosition{Start: 0, End: 5}, events.EmotePosition{Start: 3, End: 1})},
+			want:    []events.Fragment{text("Kappa")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r *Resolver
+			got := r.Fragments(events.ChatMessage{Message: tt.message, Emotes: tt.emotes})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fragments(%q) =\n%+v\nwant\n%+v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFragmentsThirdPartyEmotes(t *testing.T) {
+	bttv := &Static{ProviderName: "bttv", Sets: map[string][]Emote{
+		"": {
+			{ID: "b1", Code: "catJAM", Provider: "bttv", URL: "https://cdn.betterttv.net/emote/b1/1x"},
+			{ID: "b2", Code: "Kappa", Provider: "bttv", URL: "https://cdn.betterttv.net/emote/b2/1x"},
+		},
+	}}
+	ffz := &Static{ProviderName: "ffz", Sets: map[string][]Emote{
+		"": {
+			{ID: "f1", Code: "catJAM", Provider: "ffz", URL: "https://cdn.frankerfacez.com/emote/f1/1"},
+			{ID: "f2", Code: "monkaS", Provider: "ffz", URL: "https://cdn.frankerfacez.com/emote/f2/1"},
+		},
+	}}
+	seventv := &Static{ProviderName: "7tv", Sets: map[string][]Emote{
+		"": {
+			{ID: "s1", Code: "catJAM", Provider: "7tv", URL: "https://cdn.7tv.app/emote/s1/1x.webp"},
+			{ID: "s2", Code: "monkaS", Provider: "7tv", URL: "https://cdn.7tv.app/emote/s2/1x.webp"},
+		},
+		testChannelID: {
+			{ID: "s3", Code: "catJAM", Provider: "7tv", URL: "https://cdn.7tv.app/emote/s3/1x.webp"},
+		},
+	}}
+
+	tests := []struct {
+		name      string
+		providers []Provider
+		channelID string
+		message   string
+		emotes    []events.Emote
+		want      []events.Fragment
+	}{
+		{
+			name:      "earlier provider wins: bttv over ffz and 7tv",
+			providers: []Provider{bttv, ffz, seventv},
+			message:   "catJAM",
+			want:      []events.Fragment{emote("catJAM", "b1", "bttv", "https://cdn.betterttv.net/emote/b1/1x")},
+		},
+		{
+			name:      "earlier provider wins: ffz over 7tv",
+			providers: []Provider{bttv, ffz, seventv},
+			message:   "monkaS",
+			want:      []events.Fragment{emote("monkaS", "f2", "ffz", "https://cdn.frankerfacez.com/emote/f2/1")},
+		},
+		{
+			name:      "provider order is configurable",
+			providers: []Provider{seventv, ffz, bttv},
+			message:   "monkaS",
+			want:      []events.Fragment{emote("monkaS", "s2", "7tv", "https://cdn.7tv.app/emote/s2/1x.webp")},
+		},
+		{
+			name:      "channel emotes win over global ones",
+			providers: []Provider{bttv, ffz, seventv},
+			channelID: testChannelID,
+			message:   "catJAM",
+			want:      []events.Fragment{emote("catJAM", "s3", "7tv", "https://cdn.7tv.app/emote/s3/1x.webp")},
+		},
+		{
+			name:      "twitch emote positions win over third-party codes",
+			providers: []Provider{bttv},
+			message:   "Kappa catJAM",
+			emotes:    []events.Emote{twitchEmote("25", events.EmotePosition{Start: 0, End: 4})},
+			want:      []events.Fragment{kappa("Kappa"), text(" "), emote("catJAM", "b1", "bttv", "https://cdn.betterttv.net/emote/b1/1x")},
+		},
+		{
+			name:      "codes only match whole words",
+			providers: []Provider{bttv},
+			message:   "catJAMs xcatJAM",
+			want:      []events.Fragment{text("catJAMs xcatJAM")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestResolver(tt.providers...)
+			got := r.Fragments(events.ChatMessage{ChannelID: tt.channelID, Message: tt.message, Emotes: tt.emotes})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fragments(%q) =\n%+v\nwant\n%+v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package emotes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errNotFound is returned by getJSON for 404 responses, which the providers
+// answer for channels they do not know.
+var errNotFound = errors.New("not found")
+
+// NewProviders returns the providers with the given names (bttv, ffz, 7tv)
+// fetching over httpClient, or http.DefaultClient if it is nil.
+func NewProviders(names []string, httpClient *http.Client) ([]Provider, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	var providers []Provider
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "bttv":
+			providers = append(providers, &BTTV{HTTP: httpClient})
+		case "ffz":
+			providers = append(providers, &FFZ{HTTP: httpClient})
+		case "7tv":
+			providers = append(providers, &SevenTV{HTTP: httpClient})
+		default:
+			return nil, fmt.Errorf("unknown emote provider %q", name)
+		}
+	}
+	return providers, nil
+}
+
+// Static is a Provider serving fixed emote sets, keyed by channel ID with ""
+// for the global set. It is meant for tests and fixtures.
+type Static struct {
+	ProviderName string
+	Sets         map[string][]Emote
+}
+
+func (s *Static) Name() string { return s.ProviderName }
+
+func (s *Static) Emotes(ctx context.Context, channelID string) ([]Emote, error) {
+	return s.Sets[channelID], nil
+}
+
+// BTTV fetches BetterTTV emotes.
+type BTTV struct {
+	HTTP *http.Client
+}
+
+func (*BTTV) Name() string { return "bttv" }
+
+type bttvEmote struct {
+	ID   string `json:"id"`
+	Code string `json:"code"`
+}
+
+func (b *BTTV) Emotes(ctx context.Context, channelID string) ([]Emote, error) {
+	var list []bttvEmote
+	if channelID == "" {
+		if err := getJSON(ctx, b.HTTP, "https://api.betterttv.net/3/cached/emotes/global", &list); err != nil {
+			return nil, err
+		}
+	} else {
+		var user struct {
+			ChannelEmotes []bttvEmote `json:"channelEmotes"`
+			SharedEmotes  []bttvEmote `json:"sharedEmotes"`
+		}
+		err := getJSON(ctx, b.HTTP, "https://api.betterttv.net/3/cached/users/twitch/"+channelID, &user)
+		if errors.Is(err, errNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(user.ChannelEmotes, user.SharedEmotes...)
+	}
+	emotes := make([]Emote, 0, len(list))
+	for _, e := range list {
+		emotes = append(emotes, Emote{ID: e.ID, Code: e.Code, Provider: "bttv", URL: "https://cdn.betterttv.net/emote/" + e.ID + "/1x"})
+	}
+	return emotes, nil
+}
+
+// FFZ fetches FrankerFaceZ emotes.
+type FFZ struct {
+	HTTP *http.Client
+}
+
+func (*FFZ) Name() string { return "ffz" }
+
+type ffzSets map[string]struct {
+	Emoticons []struct {
+		ID   int               `json:"id"`
+		Name string            `json:"name"`
+		URLs map[string]string `json:"urls"`
+	} `json:"emoticons"`
+}
+
+func (f *FFZ) Emotes(ctx context.Context, channelID string) ([]Emote, error) {
+	var res struct {
+		// DefaultSets are the global sets; the global response also
+		// holds sets only some users can see.
+		DefaultSets []int   `json:"default_sets"`
+		Sets        ffzSets `json:"sets"`
+	}
+	u := "https://api.frankerfacez.com/v1/set/global"
+	if channelID != "" {
+		u = "https://api.frankerfacez.com/v1/room/id/" + channelID
+	}
+	err := getJSON(ctx, f.HTTP, u, &res)
+	if errors.Is(err, errNotFound) && channelID != "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var emotes []Emote
+	for id, set := range res.Sets {
+		if channelID == "" && !slices.Contains(res.DefaultSets, atoi(id)) {
+			continue
+		}
+		for _, e := range set.Emoticons {
+			emotes = append(emotes, Emote{ID: fmt.Sprint(e.ID), Code: e.Name, Provider: "ffz", URL: e.URLs["1"]})
+		}
+	}
+	return emotes, nil
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// SevenTV fetches 7TV emotes.
+type SevenTV struct {
+	HTTP *http.Client
+}
+
+func (*SevenTV) Name() string { return "7tv" }
+
+type sevenTVSet struct {
+	Emotes []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"emotes"`
+}
+
+func (s *SevenTV) Emotes(ctx context.Context, channelID string) ([]Emote, error) {
+	var set sevenTVSet
+	if channelID == "" {
+		if err := getJSON(ctx, s.HTTP, "https://7tv.io/v3/emote-sets/global", &set); err != nil {
+			return nil, err
+		}
+	} else {
+		var user struct {
+			EmoteSet *sevenTVSet `json:"emote_set"`
+		}
+		err := getJSON(ctx, s.HTTP, "https://7tv.io/v3/users/twitch/"+channelID, &user)
+		if errors.Is(err, errNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if user.EmoteSet != nil {
+			set = *user.EmoteSet
+		}
+	}
+	emotes := make([]Emote, 0, len(set.Emotes))
+	for _, e := range set.Emotes {
+		emotes = append(emotes, Emote{ID: e.ID, Code: e.Name, Provider: "7tv", URL: "https://cdn.7tv.app/emote/" + e.ID + "/1x.webp"})
+	}
+	return emotes, nil
+}
+
+// getJSON fetches u and decodes the JSON response into out.
+func getJSON(ctx context.Context, client *http.Client, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d, body %s", resp.StatusCode, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package events
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuffer is the queue length used when Subscribe is given none.
+const DefaultBuffer = 100
+
+// Filter selects the events a subscription receives. Empty fields match
+// everything.
+type Filter struct {
+	Topics  []Topic
+	Channel string
+}
+
+func (f Filter) match(e Event) bool {
+	if len(f.Topics) > 0 && !slices.Contains(f.Topics, e.Topic()) {
+		return false
+	}
+	return f.Channel == "" || f.Channel == e.EventChannel()
+}
+
+// Bus fans published events out to subscribers. Every subscriber has its own
+// buffered queue, so a slow consumer only loses its own events and never
+// holds up the publisher or other subscribers. A Bus is safe for concurrent
+// use.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives the events matching its filter on C until Close.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	filter  Filter
+	bus     *Bus
+	closed  bool
+	dropped atomic.Uint64
+}
+
+// Subscribe returns a subscription to the events matching f, queueing up to
+// buffer of them (DefaultBuffer if buffer <= 0).
+func (b *Bus) Subscribe(f Filter, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c, filter: f, bus: b}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Publish delivers e to every matching subscriber whose queue has room.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.filter.match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Close stops delivery and closes C. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.bus.subs, s)
+	close(s.c)
+}
+
+// Dropped returns how many events were discarded because the queue was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
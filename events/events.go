@@ -0,0 +1,421 @@
+// Package events is the in-process event bus. Chat and EventSub sources
+// publish typed events to a Bus; WebSocket and SSE clients, the bot and any
+// other consumer subscribe to the topics and channels they need and all see
+// the same stream.
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Topic names a kind of event.
+type Topic string
+
+const (
+	TopicChatMessage Topic = "chat.message"
+	TopicNotice      Topic = "chat.notice"
+	TopicUserNotice  Topic = "chat.usernotice"
+	TopicClearChat   Topic = "chat.clearchat"
+	TopicClearMsg    Topic = "chat.clearmsg"
+	TopicRoomState   Topic = "chat.roomstate"
+	TopicEventSub    Topic = "eventsub.notification"
+)
+
+// Event is implemented by every event published on a Bus.
+type Event interface {
+	Topic() Topic
+	// EventChannel is the login of the channel the event belongs to, or
+	// empty if it belongs to none.
+	EventChannel() string
+}
+
+// Header holds the fields every event carries.
+type Header struct {
+	Channel string    `json:"channel"`
+	Time    time.Time `json:"time"`
+}
+
+func (h Header) EventChannel() string { return h.Channel }
+
+// ChatMessageVersion is the version of the ChatMessage JSON schema. It is
+// bumped whenever a field is renamed, removed or changes meaning.
+const ChatMessageVersion = 1
+
+// ChatMessage is a message sent to a channel's chat. Its JSON encoding is
+// the chat message schema every transport emits; it carries "type" and
+// "version" fields besides the ones below. Time is Twitch's server
+// timestamp.
+type ChatMessage struct {
+	Header
+	ID          string `json:"id"`
+	ChannelID   string `json:"channel_id"`
+	UserID      string `json:"user_id"`
+	User        string `json:"user"`
+	DisplayName string `json:"display_name"`
+	// Color is the user's name color as #RRGGBB, empty if never set.
+	Color string `json:"color"`
+	// Badges maps badge names to versions, e.g. subscriber to months.
+	Badges  map[string]int `json:"badges"`
+	Message string         `json:"message"`
+	// Action is set for /me messages.
+	Action bool    `json:"action"`
+	Emotes []Emote `json:"emotes"`
+	// Bits is the amount cheered with the message.
+	Bits int `json:"bits"`
+	// FirstMessage is set for the user's first message in the channel.
+	FirstMessage bool `json:"first_msg"`
+	// Fragments is the message split into text, emotes, cheermotes,
+	// mentions and links, in order.
+	Fragments []Fragment `json:"fragments"`
+	// Reply is set when the message replies to another one.
+	Reply *Reply `json:"reply,omitempty"`
+}
+
+func (ChatMessage) Topic() Topic { return TopicChatMessage }
+
+// MarshalJSON adds the schema's type and version to the message.
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type message ChatMessage
+	return json.Marshal(struct {
+		Type    string `json:"type"`
+		Version int    `json:"version"`
+		message
+	}{"chat_message", ChatMessageVersion, message(m)})
+}
+
+// Emote is a Twitch emote used in a chat message.
+type Emote struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Positions are the character ranges the emote occupies, end inclusive.
+	Positions []EmotePosition `json:"positions"`
+}
+
+// Fragment types.
+const (
+	FragmentTypeText      = "text"
+	FragmentTypeEmote     = "emote"
+	FragmentTypeCheermote = "cheermote"
+	FragmentTypeMention   = "mention"
+	FragmentTypeURL       = "url"
+)
+
+// Fragment is a piece of a chat message. Text is the fragment as it appears
+// in the message; the field matching Type describes it further.
+type Fragment struct {
+	Type      string             `json:"type"`
+	Text      string             `json:"text"`
+	Emote     *FragmentEmote     `json:"emote,omitempty"`
+	Cheermote *FragmentCheermote `json:"cheermote,omitempty"`
+	Mention   *FragmentMention   `json:"mention,omitempty"`
+	URL       string             `json:"url,omitempty"`
+}
+
+// FragmentEmote is an emote from Twitch or a third-party provider.
+type FragmentEmote struct {
+	ID string `json:"id"`
+	// Provider is twitch, bttv, ffz or 7tv.
+	Provider string `json:"provider"`
+	// URL is the emote image at 1x scale.
+	URL string `json:"url"`
+}
+
+// FragmentCheermote is a cheer of Bits with the cheermote Prefix. Tier is
+// the minimum bits of the tier the amount falls into.
+type FragmentCheermote struct {
+	Prefix string `json:"prefix"`
+	Bits   int    `json:"bits"`
+	Tier   int    `json:"tier"`
+}
+
+// FragmentMention is an @mention of a user.
+type FragmentMention struct {
+	UserLogin string `json:"user_login"`
+}
+
+// EmotePosition is a range of characters in a chat message.
+type EmotePosition struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Reply identifies the message a chat message replies to and the thread it
+// belongs to, from the reply-parent and reply-thread-parent tags.
+type Reply struct {
+	ParentMsgID       string `json:"parent_msg_id"`
+	ParentUserID      string `json:"parent_user_id"`
+	ParentUserLogin   string `json:"parent_user_login"`
+	ParentDisplayName string `json:"parent_display_name"`
+	ParentMsgBody     string `json:"parent_msg_body"`
+	// ThreadParentMsgID is the message that started the thread.
+	ThreadParentMsgID     string `json:"thread_parent_msg_id"`
+	ThreadParentUserLogin string `json:"thread_parent_user_login"`
+}
+
+// Notice is a NOTICE from the chat server, e.g. a rejected message.
+type Notice struct {
+	Header
+	MsgID   string `json:"msg_id"`
+	Message string `json:"message"`
+}
+
+func (Notice) Topic() Topic { return TopicNotice }
+
+// MarshalJSON adds the type "notice" to the notice, as the transports
+// tell events apart by it.
+func (n Notice) MarshalJSON() ([]byte, error) {
+	type event Notice
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		event
+	}{"notice", event(n)})
+}
+
+// UserNotice types. Notices of the other types are published as a plain
+// UserNotice of type UserNoticeOther.
+const (
+	UserNoticeSub           = "sub"
+	UserNoticeResub         = "resub"
+	UserNoticeSubGift       = "subgift"
+	UserNoticeMysteryGift   = "submysterygift"
+	UserNoticeGiftUpgrade   = "giftpaidupgrade"
+	UserNoticeRaid          = "raid"
+	UserNoticeAnnouncement  = "announcement"
+	UserNoticeBitsBadgeTier = "bitsbadgetier"
+	UserNoticeOther         = "usernotice"
+)
+
+// UserNotice is a USERNOTICE: subscriptions, gifts, raids, announcements.
+// The notices of the known types are published as their own events, which
+// embed UserNotice and add the parsed msg-param tags; all of them share
+// TopicUserNotice.
+type UserNotice struct {
+	Header
+	// Type is one of the UserNotice types and names the event in JSON.
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	// MsgID is Twitch's msg-id tag, e.g. resub or anonsubgift.
+	MsgID       string `json:"msg_id"`
+	UserID      string `json:"user_id"`
+	User        string `json:"user"`
+	DisplayName string `json:"display_name"`
+	SystemMsg   string `json:"system_msg"`
+	// Message is the user's own message, e.g. shared with a resub.
+	Message string `json:"message"`
+	// Params are the raw msg-param tags.
+	Params map[string]string `json:"params,omitempty"`
+}
+
+func (UserNotice) Topic() Topic { return TopicUserNotice }
+
+// Notice returns n; the typed notices return the UserNotice they embed.
+func (n UserNotice) Notice() UserNotice { return n }
+
+// UserNoticeEvent is implemented by UserNotice and the typed notices.
+type UserNoticeEvent interface {
+	Event
+	Notice() UserNotice
+}
+
+// Sub is a new subscription or, with type UserNoticeResub, a renewed one.
+type Sub struct {
+	UserNotice
+	// Plan is 1000, 2000 or 3000 for tier 1 to 3, or Prime.
+	Plan     string `json:"plan"`
+	PlanName string `json:"plan_name"`
+	// CumulativeMonths is the total months subscribed.
+	CumulativeMonths int `json:"cumulative_months"`
+	// StreakMonths is the months subscribed in a row, 0 unless the user
+	// shares it.
+	StreakMonths int `json:"streak_months"`
+	// MultiMonthDuration is the months paid for in advance.
+	MultiMonthDuration int `json:"multimonth_duration"`
+}
+
+// SubGift is a subscription gifted to a single user.
+type SubGift struct {
+	UserNotice
+	Plan                 string `json:"plan"`
+	PlanName             string `json:"plan_name"`
+	RecipientID          string `json:"recipient_id"`
+	RecipientUser        string `json:"recipient_user"`
+	RecipientDisplayName string `json:"recipient_display_name"`
+	// Months is the recipient's total months subscribed.
+	Months int `json:"months"`
+	// GiftMonths is the months gifted.
+	GiftMonths int `json:"gift_months"`
+	// SenderCount is the gifter's total gifts in the channel, 0 if hidden.
+	SenderCount int `json:"sender_count"`
+	// Anonymous is set when the gifter is hidden.
+	Anonymous bool `json:"anonymous"`
+	// CommunityGiftID ties the gift to the MysteryGift it is part of.
+	CommunityGiftID string `json:"community_gift_id,omitempty"`
+}
+
+// MysteryGift announces subscriptions gifted to random users in the
+// channel; a SubGift follows for each of them.
+type MysteryGift struct {
+	UserNotice
+	Plan string `json:"plan"`
+	// Count is the subscriptions gifted.
+	Count           int    `json:"count"`
+	SenderCount     int    `json:"sender_count"`
+	Anonymous       bool   `json:"anonymous"`
+	CommunityGiftID string `json:"community_gift_id,omitempty"`
+}
+
+// GiftUpgrade is a gifted or Prime subscription continued as a paid one.
+type GiftUpgrade struct {
+	UserNotice
+	// Plan is set for Prime upgrades.
+	Plan string `json:"plan,omitempty"`
+	// SenderUser is the login of the original gifter, empty if anonymous.
+	SenderUser        string `json:"sender_user,omitempty"`
+	SenderDisplayName string `json:"sender_display_name,omitempty"`
+	Anonymous         bool   `json:"anonymous"`
+}
+
+// Raid is an incoming raid; User is the raiding broadcaster.
+type Raid struct {
+	UserNotice
+	ViewerCount     int    `json:"viewer_count"`
+	ProfileImageURL string `json:"profile_image_url"`
+}
+
+// Announcement is a message sent with /announce.
+type Announcement struct {
+	UserNotice
+	// Color is PRIMARY, BLUE, GREEN, ORANGE or PURPLE.
+	Color string `json:"color"`
+}
+
+// BitsBadgeTier is a user earning a new bits badge.
+type BitsBadgeTier struct {
+	UserNotice
+	// Threshold is the bits the badge tier requires.
+	Threshold int `json:"threshold"`
+}
+
+// ClearChat actions.
+const (
+	ClearChatClear   = "clear"
+	ClearChatBan     = "ban"
+	ClearChatTimeout = "timeout"
+)
+
+// ClearChat is a CLEARCHAT: a timeout, ban or cleared chat.
+type ClearChat struct {
+	Header
+	ChannelID string `json:"channel_id"`
+	// Action is ClearChatClear when the whole chat was cleared, else
+	// ClearChatBan or ClearChatTimeout of the target user.
+	Action       string `json:"action"`
+	TargetUserID string `json:"target_user_id,omitempty"`
+	TargetUser   string `json:"target_user,omitempty"`
+	// BanDuration is the timeout in seconds, 0 for bans.
+	BanDuration int `json:"ban_duration,omitempty"`
+}
+
+func (ClearChat) Topic() Topic { return TopicClearChat }
+
+// MarshalJSON adds the type "clearchat" to the event.
+func (n ClearChat) MarshalJSON() ([]byte, error) {
+	type event ClearChat
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		event
+	}{"clearchat", event(n)})
+}
+
+// ClearMsg is a CLEARMSG: a single deleted message.
+type ClearMsg struct {
+	Header
+	// TargetMsgID is the ID of the deleted message.
+	TargetMsgID string `json:"target_msg_id"`
+	// User is the login of the message's sender.
+	User    string `json:"user"`
+	Message string `json:"message"`
+}
+
+func (ClearMsg) Topic() Topic { return TopicClearMsg }
+
+// MarshalJSON adds the type "clearmsg" to the event.
+func (n ClearMsg) MarshalJSON() ([]byte, error) {
+	type event ClearMsg
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		event
+	}{"clearmsg", event(n)})
+}
+
+// RoomSettings are a channel's chat modes.
+type RoomSettings struct {
+	EmoteOnly bool `json:"emote_only"`
+	// FollowersOnly is the minutes a user must follow to chat, -1 if off.
+	FollowersOnly int  `json:"followers_only"`
+	R9K           bool `json:"r9k"`
+	// Slow is the seconds between a user's messages, 0 if off.
+	Slow     int  `json:"slow"`
+	SubsOnly bool `json:"subs_only"`
+}
+
+// Diff returns the JSON names of the settings that differ between s and
+// old.
+func (s RoomSettings) Diff(old RoomSettings) []string {
+	changed := []string{}
+	if s.EmoteOnly != old.EmoteOnly {
+		changed = append(changed, "emote_only")
+	}
+	if s.FollowersOnly != old.FollowersOnly {
+		changed = append(changed, "followers_only")
+	}
+	if s.R9K != old.R9K {
+		changed = append(changed, "r9k")
+	}
+	if s.Slow != old.Slow {
+		changed = append(changed, "slow")
+	}
+	if s.SubsOnly != old.SubsOnly {
+		changed = append(changed, "subs_only")
+	}
+	return changed
+}
+
+// RoomState is a ROOMSTATE: the channel's chat settings. Twitch only sends
+// the changed modes after the first ROOMSTATE; Settings always holds all of
+// them.
+type RoomState struct {
+	Header
+	ChannelID string       `json:"channel_id"`
+	Settings  RoomSettings `json:"settings"`
+	// Previous are the settings before this change, nil for the state
+	// received on joining.
+	Previous *RoomSettings `json:"previous,omitempty"`
+	// Changed names the settings that differ from Previous.
+	Changed []string `json:"changed"`
+}
+
+func (RoomState) Topic() Topic { return TopicRoomState }
+
+// MarshalJSON adds the type "roomstate" to the event.
+func (n RoomState) MarshalJSON() ([]byte, error) {
+	type event RoomState
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		event
+	}{"roomstate", event(n)})
+}
+
+// EventSubNotification is an EventSub notification, whichever transport
+// delivered it. Event is the subscription type specific payload.
+type EventSubNotification struct {
+	Header
+	MessageID string          `json:"message_id"`
+	Type      string          `json:"type"`
+	Version   string          `json:"version"`
+	Event     json.RawMessage `json:"event"`
+}
+
+func (EventSubNotification) Topic() Topic { return TopicEventSub }
@@ -1,13 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"os"
-	"time"
+	"strings"
 
+	"go-twitch/config"
 	"go-twitch/twitch"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,88 +13,213 @@ func AuthorizePage(c *fiber.Ctx) error {
 	return c.SendFile("./static/authorize.html")
 }
 
-func AuthStart(c *fiber.Ctx) error {
-	clientID := os.Getenv("TWITCH_CLIENT_ID")
-	redirectURI := os.Getenv("TWITCH_REDIRECT_URI")
-	scopes := "chat:read chat:edit user:read:email"
-	state := "secure_random_state"
-	authURL := "https://id.twitch.tv/oauth2/authorize" +
-		"?client_id=" + url.QueryEscape(clientID) +
-		"&redirect_uri=" + url.QueryEscape(redirectURI) +
-		"&response_type=code" +
-		"&scope=" + url.QueryEscape(scopes) +
-		"&state=" + url.QueryEscape(state)
-	return c.Redirect(authURL)
+// AuthStart redirects to Twitch for authorization. The optional role query
+// parameter (bot, broadcaster or moderator) says what the account will be
+// used for; scopes is a comma separated list of feature names or raw scopes
+// to request on top of the configured ones. With account set to a known user
+// ID, the scopes that account was already granted are kept.
+func AuthStart(cfg config.Config, accounts *twitch.Accounts, states *OAuthStates) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, err := twitch.ParseRole(c.Query("role"))
+		if err != nil {
+			return authErrorPage(c, 400, err.Error())
+		}
+		var granted []string
+		if m := accounts.Get(c.Query("account")); m != nil {
+			granted = m.Current().Scopes
+		}
+		scopes := cfg.RequestScopes(granted, strings.Split(c.Query("scopes"), ","))
+		state, err := states.Issue(c, loginRequest{ReturnTo: c.Query("return"), Role: string(role)})
+		if err != nil {
+			return authErrorPage(c, 500, "Could not start login: "+err.Error())
+		}
+		return c.Redirect(twitch.AuthorizeURL(cfg, scopes, state))
+	}
 }
 
-func AuthCallback(c *fiber.Ctx) error {
-	code := c.Query("code")
-	if code == "" {
-		return c.Status(400).SendString("Missing code parameter")
-	}
-	clientID := os.Getenv("TWITCH_CLIENT_ID")
-	clientSecret := os.Getenv("TWITCH_CLIENT_SECRET")
-	redirectURI := os.Getenv("TWITCH_REDIRECT_URI")
-	resp, err := http.PostForm("https://id.twitch.tv/oauth2/token", url.Values{
-		"client_id":     {clientID},
-		"client_secret": {clientSecret},
-		"code":          {code},
-		"grant_type":    {"authorization_code"},
-		"redirect_uri":  {redirectURI},
-	})
-	if err != nil {
-		return c.Status(500).SendString("Failed to exchange code: " + err.Error())
+func AuthCallback(cfg config.Config, accounts *twitch.Accounts, states *OAuthStates) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		login, err := states.Verify(c, c.Query("state"))
+		if err != nil {
+			return authErrorPage(c, 400, err.Error()+". Please start the login again.")
+		}
+		if reason := c.Query("error"); reason != "" {
+			return authErrorPage(c, 400, "Twitch reported: "+c.Query("error_description", reason))
+		}
+		code := c.Query("code")
+		if code == "" {
+			return authErrorPage(c, 400, "Missing code parameter")
+		}
+		role, err := twitch.ParseRole(login.Role)
+		if err != nil {
+			return authErrorPage(c, 400, err.Error())
+		}
+		token, err := twitch.ExchangeCode(c.UserContext(), cfg, code)
+		if err != nil {
+			return authErrorPage(c, 500, err.Error())
+		}
+		// Persists the token under the account's user ID and hands it to
+		// live IRC clients logged in as that account.
+		if _, err := accounts.Add(c.UserContext(), token, role); err != nil {
+			return authErrorPage(c, 500, err.Error())
+		}
+		if login.ReturnTo == "" {
+			login.ReturnTo = "/authorize"
+		}
+		return c.Redirect(login.ReturnTo)
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		return c.Status(500).SendString("Twitch token exchange failed: " + string(body))
+}
+
+// AuthStatus reports the app token and every authorized account. The
+// top-level user fields describe the account selected with ?account=<user
+// id>, or the default account.
+func AuthStatus(cfg config.Config, appTokens *twitch.TokenManager, accounts *twitch.Accounts) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		app := appTokens.Current()
+		status := fiber.Map{
+			"authorized":     false,
+			"app_authorized": appTokens.Valid(),
+			"app_expires_at": app.ExpiresAt,
+		}
+		if v := appTokens.Validation(); v != nil {
+			status["app_validated_at"] = v.CheckedAt
+			status["app_validation_error"] = v.Error
+		}
+
+		list := []fiber.Map{}
+		for _, m := range accounts.List() {
+			list = append(list, accountStatus(cfg, m))
+		}
+		status["accounts"] = list
+
+		selected := accounts.Get(c.Query("account"))
+		if selected == nil {
+			selected = accounts.Default()
+		}
+		var scopes []string
+		if selected != nil {
+			user := selected.Current()
+			scopes = user.Scopes
+			status["authorized"] = selected.Valid()
+			status["user_expires_at"] = user.ExpiresAt
+			status["user_scopes"] = user.Scopes
+			status["user_refreshable"] = user.RefreshToken != ""
+			status["user_login"] = user.Login
+			status["user_id"] = user.UserID
+			status["user_roles"] = user.Roles
+			if v := selected.Validation(); v != nil {
+				status["user_validated_at"] = v.CheckedAt
+				status["user_validation_error"] = v.Error
+			}
+		}
+		status["features"], status["unavailable_features"] = featureStatus(cfg, scopes)
+		return c.JSON(status)
 	}
-	var tokenResp struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
+}
+
+func accountStatus(cfg config.Config, m *twitch.TokenManager) fiber.Map {
+	t := m.Current()
+	_, unavailable := featureStatus(cfg, t.Scopes)
+	status := fiber.Map{
+		"user_id":              t.UserID,
+		"login":                t.Login,
+		"roles":                t.Roles,
+		"authorized":           m.Valid(),
+		"expires_at":           t.ExpiresAt,
+		"scopes":               t.Scopes,
+		"refreshable":          t.RefreshToken != "",
+		"unavailable_features": unavailable,
 	}
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return c.Status(500).SendString("Failed to parse token response: " + err.Error())
+	if v := m.Validation(); v != nil {
+		status["validated_at"] = v.CheckedAt
+		status["validation_error"] = v.Error
 	}
-	// preserve existing behavior writing to env through twitch.UpdateEnvFile
-	expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
-	_ = twitch.UpdateEnvFile("TWITCH_USER_ACCESS_TOKEN", tokenResp.AccessToken)
-	_ = twitch.UpdateEnvFile("TWITCH_USER_ACCESS_TOKEN_EXPIRES_AT", expiresAt.Format(time.RFC3339))
-	os.Setenv("TWITCH_USER_ACCESS_TOKEN", tokenResp.AccessToken)
-	os.Setenv("TWITCH_USER_ACCESS_TOKEN_EXPIRES_AT", expiresAt.Format(time.RFC3339))
-	return c.Redirect("/authorize")
+	return status
 }
 
-func AuthStatus(c *fiber.Ctx) error {
-	userToken := os.Getenv("TWITCH_USER_ACCESS_TOKEN")
-	userExpiresStr := os.Getenv("TWITCH_USER_ACCESS_TOKEN_EXPIRES_AT")
-	appToken := os.Getenv("TWITCH_APP_ACCESS_TOKEN")
-	appExpiresStr := os.Getenv("TWITCH_APP_ACCESS_TOKEN_EXPIRES_AT")
-	
-	var userExpires time.Time
-	var appExpires time.Time
-	var appAuthorized bool
-	
-	if userToken != "" && userExpiresStr != "" {
-		if parsed, err := time.Parse(time.RFC3339, userExpiresStr); err == nil {
-			userExpires = parsed
+func featureStatus(cfg config.Config, scopes []string) (fiber.Map, []string) {
+	features := fiber.Map{}
+	unavailable := []string{}
+	for _, name := range cfg.FeatureNames() {
+		missing := cfg.MissingScopes(name, scopes)
+		features[name] = fiber.Map{"available": len(missing) == 0, "missing_scopes": missing}
+		if len(missing) > 0 {
+			unavailable = append(unavailable, name)
 		}
 	}
-	
-	if appToken != "" && appExpiresStr != "" {
-		if parsed, err := time.Parse(time.RFC3339, appExpiresStr); err == nil {
-			appExpires = parsed
-			appAuthorized = true
+	return features, unavailable
+}
+
+// AuthRevoke revokes user and/or app tokens with Twitch and forgets them.
+// token=user revokes the account given by account (a user ID), or the
+// default account; token=all revokes every account and the app token. IRC
+// clients logged in with a revoked user token are disconnected; a revoked
+// app token is replaced by a new one. The request must be JSON: plain
+// forms, which any page can submit, are refused.
+func AuthRevoke(cfg config.Config, appTokens *twitch.TokenManager, accounts *twitch.Accounts) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !c.Is("json") {
+			return c.Status(415).JSON(fiber.Map{"success": false, "message": "Content-Type must be application/json"})
+		}
+		var req struct {
+			Token   string `json:"token"`
+			Account string `json:"account"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"success": false, "message": "Invalid JSON body"})
+		}
+		if req.Token == "" {
+			req.Token = "user"
+		}
+
+		var users []*twitch.TokenManager
+		revokeApp := false
+		switch req.Token {
+		case "user":
+			m := accounts.Get(req.Account)
+			if req.Account == "" {
+				m = accounts.Default()
+			}
+			if m == nil {
+				return c.Status(404).JSON(fiber.Map{"success": false, "message": "No such account"})
+			}
+			users = []*twitch.TokenManager{m}
+		case "app":
+			revokeApp = true
+		case "all":
+			users = accounts.List()
+			revokeApp = true
+		default:
+			return c.Status(400).JSON(fiber.Map{"success": false, "message": "token must be user, app or all"})
+		}
+
+		revoked := []string{}
+		for _, m := range users {
+			t := m.Current()
+			if t.AccessToken != "" {
+				if err := twitch.RevokeToken(c.UserContext(), cfg.ClientID, t.AccessToken); err != nil {
+					return c.Status(502).JSON(fiber.Map{"success": false, "message": err.Error(), "revoked": revoked})
+				}
+			}
+			accounts.Remove(t.UserID)
+			revoked = append(revoked, "user:"+t.Login)
+		}
+		if revokeApp {
+			if token := appTokens.Current().AccessToken; token != "" {
+				if err := twitch.RevokeToken(c.UserContext(), cfg.ClientID, token); err != nil {
+					return c.Status(502).JSON(fiber.Map{"success": false, "message": err.Error(), "revoked": revoked})
+				}
+			}
+			appTokens.Clear()
+			revoked = append(revoked, "app")
+			// App tokens need no user interaction, so fetch a new one right
+			// away instead of failing app authenticated calls until restart.
+			if err := appTokens.Refresh(c.UserContext()); err != nil {
+				return c.Status(502).JSON(fiber.Map{"success": false, "message": err.Error(), "revoked": revoked})
+			}
 		}
+		return c.JSON(fiber.Map{"success": true, "revoked": revoked})
 	}
-	
-	return c.JSON(fiber.Map{
-		"authorized":      userToken != "",
-		"user_expires_at": userExpires,
-		"app_authorized":  appAuthorized,
-		"app_expires_at":  appExpires,
-	})
 }
 
 func CallbackAlias(c *fiber.Ctx) error {
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go-twitch/config"
+	"go-twitch/twitch"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DeviceFlows holds the Device Code Grants started from /auth/device. The
+// device code itself never leaves the server; the page polls with an opaque
+// ID instead.
+type DeviceFlows struct {
+	mu      sync.Mutex
+	pending map[string]*deviceFlow
+}
+
+type deviceFlow struct {
+	auth     *twitch.DeviceAuthorization
+	role     twitch.Role
+	lastPoll time.Time
+}
+
+func NewDeviceFlows() *DeviceFlows {
+	return &DeviceFlows{pending: make(map[string]*deviceFlow)}
+}
+
+func DevicePage(c *fiber.Ctx) error {
+	return c.SendFile("./static/device.html")
+}
+
+// DeviceStart begins a Device Code Grant for an account with the requested
+// role and returns the code to show.
+func DeviceStart(cfg config.Config, flows *DeviceFlows) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Scopes string `json:"scopes"`
+			Role   string `json:"role"`
+		}
+		_ = c.BodyParser(&req)
+		role, err := twitch.ParseRole(req.Role)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"success": false, "message": err.Error()})
+		}
+		scopes := cfg.RequestScopes(nil, strings.Split(req.Scopes, ","))
+		auth, err := twitch.StartDeviceAuth(c.UserContext(), cfg, scopes)
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{"success": false, "message": err.Error()})
+		}
+
+		idBytes := make([]byte, 16)
+		if _, err := rand.Read(idBytes); err != nil {
+			return c.Status(500).JSON(fiber.Map{"success": false, "message": err.Error()})
+		}
+		id := hex.EncodeToString(idBytes)
+
+		flows.mu.Lock()
+		for k, f := range flows.pending {
+			if time.Now().After(f.auth.ExpiresAt) {
+				delete(flows.pending, k)
+			}
+		}
+		flows.pending[id] = &deviceFlow{auth: auth, role: role}
+		flows.mu.Unlock()
+
+		return c.JSON(fiber.Map{
+			"success":          true,
+			"id":               id,
+			"user_code":        auth.UserCode,
+			"verification_uri": auth.VerificationURI,
+			"expires_at":       auth.ExpiresAt,
+			"interval":         int(auth.Interval / time.Second),
+		})
+	}
+}
+
+// DevicePoll reports whether a Device Code Grant has been approved and, once
+// it has, stores the user token the same way AuthCallback does.
+func DevicePoll(cfg config.Config, accounts *twitch.Accounts, flows *DeviceFlows) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Query("id")
+		flows.mu.Lock()
+		flow, ok := flows.pending[id]
+		if !ok {
+			flows.mu.Unlock()
+			return c.Status(404).JSON(fiber.Map{"status": "unknown", "message": "No pending device authorization with this id"})
+		}
+		// Never poll Twitch faster than it asked, however often the page polls us.
+		if time.Since(flow.lastPoll) < flow.auth.Interval {
+			flows.mu.Unlock()
+			return c.JSON(fiber.Map{"status": "pending"})
+		}
+		flow.lastPoll = time.Now()
+		auth, role := *flow.auth, flow.role
+		flows.mu.Unlock()
+
+		token, err := twitch.PollDeviceToken(c.UserContext(), cfg, &auth)
+		flows.mu.Lock()
+		flow.auth.Interval = auth.Interval
+		flows.mu.Unlock()
+		switch {
+		case errors.Is(err, twitch.ErrAuthorizationPending):
+			return c.JSON(fiber.Map{"status": "pending"})
+		case errors.Is(err, twitch.ErrDeviceCodeExpired):
+			flows.forget(id)
+			return c.JSON(fiber.Map{"status": "expired", "message": "The code expired, start again"})
+		case err != nil:
+			return c.Status(502).JSON(fiber.Map{"status": "error", "message": err.Error()})
+		}
+		flows.forget(id)
+		m, err := accounts.Add(c.UserContext(), token, role)
+		if err != nil {
+			return c.Status(502).JSON(fiber.Map{"status": "error", "message": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "authorized", "login": m.Current().Login, "expires_at": token.ExpiresAt})
+	}
+}
+
+func (f *DeviceFlows) forget(id string) {
+	f.mu.Lock()
+	delete(f.pending, id)
+	f.mu.Unlock()
+}
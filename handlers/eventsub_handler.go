@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+
+	"go-twitch/twitch"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// EventSubCallback receives EventSub webhook messages. Requests that are not
+// signed with the secret or not fresh are rejected; messages already handled
+// are acknowledged without handling them again.
+func EventSubCallback(webhook *twitch.EventSubWebhook) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Notifications outlive the request, whose buffers fiber reuses.
+		body := bytes.Clone(c.Body())
+		m, err := webhook.Verify(func(name string) string { return strings.Clone(c.Get(name)) }, body)
+		if err != nil {
+			log.Printf("[EVENTSUB] rejected callback %q: %v", m.MessageID, err)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"success": false, "message": err.Error()})
+		}
+		challenge, err := webhook.Handle(m, body)
+		switch {
+		case errors.Is(err, twitch.ErrEventSubDuplicate):
+			return c.SendStatus(fiber.StatusNoContent)
+		case err != nil:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"success": false, "message": err.Error()})
+		case m.MessageType == twitch.EventSubVerification:
+			c.Set(fiber.HeaderContentType, fiber.MIMETextPlain)
+			return c.SendString(challenge)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
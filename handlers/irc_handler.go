@@ -1,8 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"os"
+	"time"
 
 	"go-twitch/twitch"
 
@@ -10,107 +11,144 @@ import (
 )
 
 // Subscribe endpoints
-func IRCSubscribe(c *fiber.Ctx) error {
-	var req struct {
-		Channel string `json:"channel"`
+func IRCSubscribe(pool *twitch.IRCPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Channel string `json:"channel"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.Channel == "" {
+			return c.Status(400).JSON(fiber.Map{"success": false, "message": "Missing channel name"})
+		}
+		return c.JSON(subscribe(pool, req.Channel))
 	}
-	if err := c.BodyParser(&req); err != nil || req.Channel == "" {
-		return c.Status(400).JSON(fiber.Map{"success": false, "message": "Missing channel name"})
-	}
-	twitch.IRCClientsMu.Lock()
-	_, alreadyJoined := twitch.IRCClients[req.Channel]
-	twitch.IRCClientsMu.Unlock()
-	if alreadyJoined {
-		return c.JSON(fiber.Map{"success": false, "message": "Already subscribed to IRC chat for channel " + req.Channel})
-	}
-	go twitch.StartIRCRelay(req.Channel)
-	return c.JSON(fiber.Map{"success": true, "message": "Subscribed to IRC chat for channel " + req.Channel})
 }
 
-func IRCSubscribeParam(c *fiber.Ctx) error {
-	channel := c.Params("channel")
-	if channel == "" {
-		return c.Status(400).JSON(fiber.Map{"success": false, "message": "Missing channel name"})
+func IRCSubscribeParam(pool *twitch.IRCPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		channel := c.Params("channel")
+		if channel == "" {
+			return c.Status(400).JSON(fiber.Map{"success": false, "message": "Missing channel name"})
+		}
+		return c.JSON(subscribe(pool, channel))
 	}
-	twitch.IRCClientsMu.Lock()
-	_, alreadyJoined := twitch.IRCClients[channel]
-	twitch.IRCClientsMu.Unlock()
-	if alreadyJoined {
-		return c.JSON(fiber.Map{"success": false, "message": "Already subscribed to IRC chat for channel " + channel})
+}
+
+func IRCSubscribeParamHTML(pool *twitch.IRCPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		channel := c.Params("channel")
+		if channel == "" {
+			return c.Status(400).SendString("Missing channel name")
+		}
+		jsonBytes, _ := json.MarshalIndent(subscribe(pool, channel), "", "  ")
+		return c.SendString("<pre style='background:#161b22;color:#c9d1d9;padding:16px;border-radius:8px;font-size:1.1em;'>" + string(jsonBytes) + "</pre>")
 	}
-	go twitch.StartIRCRelay(channel)
-	return c.JSON(fiber.Map{"success": true, "message": "Subscribed to IRC chat for channel " + channel})
 }
 
-func IRCSubscribeParamHTML(c *fiber.Ctx) error {
-	channel := c.Params("channel")
-	if channel == "" {
-		return c.Status(400).SendString("Missing channel name")
+func subscribe(pool *twitch.IRCPool, channel string) fiber.Map {
+	started, err := pool.StartRelay(channel)
+	if err != nil {
+		return fiber.Map{"success": false, "message": err.Error()}
 	}
-	twitch.IRCClientsMu.Lock()
-	_, alreadyJoined := twitch.IRCClients[channel]
-	twitch.IRCClientsMu.Unlock()
-	var result map[string]interface{}
-	if alreadyJoined {
-		result = fiber.Map{"success": false, "message": "Already subscribed to IRC chat for channel " + channel}
-	} else {
-		go twitch.StartIRCRelay(channel)
-		result = fiber.Map{"success": true, "message": "Subscribed to IRC chat for channel " + channel}
+	if !started {
+		return fiber.Map{"success": false, "message": "Already subscribed to IRC chat for channel " + channel}
 	}
-	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
-	return c.SendString("<pre style='background:#161b22;color:#c9d1d9;padding:16px;border-radius:8px;font-size:1.1em;'>" + string(jsonBytes) + "</pre>")
+	return fiber.Map{"success": true, "message": "Subscribed to IRC chat for channel " + channel}
 }
 
 // Unsubscribe endpoints
-func IRCUnsubscribe(c *fiber.Ctx) error {
-	var req struct {
-		Channel string `json:"channel"`
+func IRCUnsubscribe(pool *twitch.IRCPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Channel string `json:"channel"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.Channel == "" {
+			return c.Status(400).JSON(fiber.Map{"success": false, "message": "Missing channel name"})
+		}
+		return c.JSON(unsubscribe(pool, req.Channel))
 	}
-	if err := c.BodyParser(&req); err != nil || req.Channel == "" {
-		return c.Status(400).JSON(fiber.Map{"success": false, "message": "Missing channel name"})
-	}
-	twitch.IRCClientsMu.Lock()
-	_, exists := twitch.IRCClients[req.Channel]
-	twitch.IRCClientsMu.Unlock()
-	if !exists {
-		return c.JSON(fiber.Map{"success": false, "message": "Not subscribed to IRC chat for channel " + req.Channel})
-	}
-	twitch.StopIRCRelay(req.Channel)
-	return c.JSON(fiber.Map{"success": true, "message": "Unsubscribed from IRC chat for channel " + req.Channel})
 }
 
-func IRCUnsubscribeParamHTML(c *fiber.Ctx) error {
-	channel := c.Params("channel")
-	if channel == "" {
-		return c.Status(400).SendString("Missing channel name")
+func IRCUnsubscribeParamHTML(pool *twitch.IRCPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		channel := c.Params("channel")
+		if channel == "" {
+			return c.Status(400).SendString("Missing channel name")
+		}
+		jsonBytes, _ := json.MarshalIndent(unsubscribe(pool, channel), "", "  ")
+		return c.SendString("<pre style='background:#161b22;color:#c9d1d9;padding:16px;border-radius:8px;font-size:1.1em;'>" + string(jsonBytes) + "</pre>")
 	}
-	twitch.IRCClientsMu.Lock()
-	_, exists := twitch.IRCClients[channel]
-	twitch.IRCClientsMu.Unlock()
-	var result map[string]interface{}
-	if !exists {
-		result = fiber.Map{"success": false, "message": "Not subscribed to IRC chat for channel " + channel}
-	} else {
-		twitch.StopIRCRelay(channel)
-		result = fiber.Map{"success": true, "message": "Unsubscribed from IRC chat for channel " + channel}
+}
+
+func unsubscribe(pool *twitch.IRCPool, channel string) fiber.Map {
+	if !pool.StopRelay(channel) {
+		return fiber.Map{"success": false, "message": "Not subscribed to IRC chat for channel " + channel}
 	}
-	jsonBytes, _ := json.MarshalIndent(result, "", "  ")
-	return c.SendString("<pre style='background:#161b22;color:#c9d1d9;padding:16px;border-radius:8px;font-size:1.1em;'>" + string(jsonBytes) + "</pre>")
+	return fiber.Map{"success": true, "message": "Unsubscribed from IRC chat for channel " + channel}
 }
 
+// ircSendTimeout bounds how long /irc/send waits for the channel to be
+// joined, the queue to drain and Twitch to confirm the message.
+const ircSendTimeout = 30 * time.Second
+
 // Send message endpoint
-func IRCSend(c *fiber.Ctx) error {
-	var req struct {
-		Channel string `json:"channel"`
-		Message string `json:"message"`
+func IRCSend(sender *twitch.ChatSender) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req struct {
+			Channel   string `json:"channel"`
+			Message   string `json:"message"`
+			Transport string `json:"transport"`
+			// ReplyTo is the ID of the message to reply to.
+			ReplyTo string `json:"reply_to"`
+		}
+		if err := c.BodyParser(&req); err != nil || req.Channel == "" || req.Message == "" {
+			return c.Status(400).JSON(fiber.Map{"success": false, "message": "Missing channel or message"})
+		}
+		transport, err := twitch.ParseChatTransport(req.Transport)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"success": false, "message": err.Error()})
+		}
+		ctx, cancel := context.WithTimeout(c.UserContext(), ircSendTimeout)
+		defer cancel()
+		result, err := sender.Send(ctx, req.Channel, req.Message, req.ReplyTo, transport)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"success": false, "message": err.Error()})
+		}
+		if !result.IsSent {
+			message := "Message not sent as " + result.Account
+			if result.DropReason != nil {
+				message += ": " + result.DropReason.Code
+				if result.DropReason.Message != "" {
+					message += " (" + result.DropReason.Message + ")"
+				}
+			}
+			return c.Status(sendFailureStatus(result.DropReason)).JSON(fiber.Map{"success": false, "message": message, "result": result})
+		}
+		return c.JSON(fiber.Map{"success": true, "message": "Message sent as " + result.Account + " via " + string(result.Transport), "result": result})
 	}
-	if err := c.BodyParser(&req); err != nil || req.Channel == "" || req.Message == "" {
-		return c.Status(400).JSON(fiber.Map{"success": false, "message": "Missing channel or message"})
+}
+
+// sendFailureStatus maps the reason a message was not sent to a status code.
+func sendFailureStatus(reason *twitch.DropReason) int {
+	if reason == nil {
+		return fiber.StatusForbidden
 	}
-	if err := twitch.SendChatMessage(req.Channel, req.Message); err != nil {
-		return c.Status(500).JSON(fiber.Map{"success": false, "message": err.Error()})
+	switch reason.Code {
+	case twitch.ReasonQueueFull, "msg_ratelimit":
+		return fiber.StatusTooManyRequests
+	case twitch.ReasonTimeout, twitch.ReasonUnconfirmed:
+		return fiber.StatusGatewayTimeout
+	case twitch.ReasonNotJoined:
+		return fiber.StatusServiceUnavailable
+	default:
+		return fiber.StatusForbidden
+	}
+}
+
+// IRCQueue reports the outgoing chat queue of every account.
+func IRCQueue(pool *twitch.IRCPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"success": true, "queues": pool.QueueStats()})
 	}
-	return c.JSON(fiber.Map{"success": true, "message": "Message sent as " + os.Getenv("TWITCH_BOT_USERNAME")})
 }
 
 // WebsocketHandler moved to server/websocket.go
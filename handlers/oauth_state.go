@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	stateCookie = "oauth_state"
+	stateTTL    = 10 * time.Minute
+)
+
+// OAuthStates issues and verifies the OAuth state parameter. Each login gets
+// a random nonce that is stored in a short-lived HttpOnly cookie and embedded,
+// together with the post-login return URL, in an HMAC-signed state value.
+type OAuthStates struct {
+	key []byte
+}
+
+type statePayload struct {
+	Nonce   string `json:"n"`
+	Expires int64  `json:"e"`
+	loginRequest
+}
+
+// loginRequest is what a login was started for, carried through Twitch in
+// the state.
+type loginRequest struct {
+	ReturnTo string `json:"r,omitempty"`
+	Role     string `json:"o,omitempty"`
+}
+
+// NewOAuthStates returns an OAuthStates signing with secret. With an empty
+// secret a random key is generated, so pending logins do not survive a
+// restart.
+func NewOAuthStates(secret string) *OAuthStates {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic("oauth state: " + err.Error())
+		}
+	}
+	return &OAuthStates{key: key}
+}
+
+// Issue creates a state for a new login, sets the matching cookie on c and
+// returns the value to send to Twitch.
+func (s *OAuthStates) Issue(c *fiber.Ctx, login loginRequest) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	login.ReturnTo = safeReturnURL(login.ReturnTo)
+	p := statePayload{
+		Nonce:        base64.RawURLEncoding.EncodeToString(nonce),
+		Expires:      time.Now().Add(stateTTL).Unix(),
+		loginRequest: login,
+	}
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	body := base64.RawURLEncoding.EncodeToString(raw)
+
+	c.Cookie(&fiber.Cookie{
+		Name:     stateCookie,
+		Value:    p.Nonce,
+		Path:     "/",
+		Expires:  time.Unix(p.Expires, 0),
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return body + "." + s.sign(body), nil
+}
+
+// Verify checks state against the cookie set by Issue, clears the cookie and
+// returns the login request carried in the state.
+func (s *OAuthStates) Verify(c *fiber.Ctx, state string) (loginRequest, error) {
+	cookie := c.Cookies(stateCookie)
+	c.ClearCookie(stateCookie)
+
+	body, sig, ok := strings.Cut(state, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(body))) {
+		return loginRequest{}, errors.New("the login request could not be verified")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return loginRequest{}, errors.New("the login request could not be verified")
+	}
+	var p statePayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return loginRequest{}, errors.New("the login request could not be verified")
+	}
+	if time.Now().Unix() > p.Expires {
+		return loginRequest{}, errors.New("the login request has expired")
+	}
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(p.Nonce)) != 1 {
+		return loginRequest{}, errors.New("the login was started in a different browser session")
+	}
+	return p.loginRequest, nil
+}
+
+func (s *OAuthStates) sign(body string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// safeReturnURL only allows local paths so the state cannot be used as an
+// open redirect.
+func safeReturnURL(u string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.ContainsAny(u, "\\\r\n") {
+		return ""
+	}
+	return u
+}
+
+// authErrorPage renders a small HTML page explaining why login failed.
+func authErrorPage(c *fiber.Ctx, status int, message string) error {
+	c.Type("html")
+	return c.Status(status).SendString("<h2>Authorization failed</h2><p>" + html.EscapeString(message) +
+		"</p><p><a href=\"/authorize\">Try again</a></p>")
+}
@@ -1,27 +1,58 @@
 package handlers
 
 import (
+	"fmt"
+	"strconv"
+
 	"go-twitch/twitch"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func GetStream(c *fiber.Ctx) error {
-	username := c.Params("name")
-	if username == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "Streamer name parameter is missing"})
+func GetStream(client *twitch.Client) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		username := c.Params("name")
+		if username == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "Streamer name parameter is missing"})
+		}
+		data, err := client.GetStreamInfo(c.UserContext(), username)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(data)
 	}
-	data, err := twitch.GetStreamInfo(username)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+func GetTopGames(client *twitch.Client) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		params, err := pageParams(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		data, err := client.GetTopGames(c.UserContext(), params)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(data)
 	}
-	return c.JSON(data)
 }
 
-func GetTopGames(c *fiber.Ctx) error {
-	data, err := twitch.GetTopGames()
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+// pageParams reads the Helix paging parameters (first, after, before) from
+// the query string so they can be passed straight through.
+func pageParams(c *fiber.Ctx) (twitch.PageParams, error) {
+	params := twitch.PageParams{
+		After:  c.Query("after"),
+		Before: c.Query("before"),
+	}
+	if params.After != "" && params.Before != "" {
+		return params, fmt.Errorf("after and before are mutually exclusive")
+	}
+	if first := c.Query("first"); first != "" {
+		n, err := strconv.Atoi(first)
+		if err != nil || n < 1 || n > 100 {
+			return params, fmt.Errorf("first must be a number between 1 and 100")
+		}
+		params.First = n
 	}
-	return c.JSON(data)
+	return params, nil
 }
@@ -1,22 +1,99 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 
 	"go-twitch/config"
+	"go-twitch/emotes"
+	"go-twitch/events"
 	"go-twitch/server"
 	"go-twitch/twitch"
 )
 
 func main() {
 	cfg := config.Load()
+	if runCommand(cfg, os.Args[1:]) {
+		return
+	}
+	ctx := context.Background()
 
-	if err := twitch.InitAppAccessToken(); err != nil {
+	store := openTokenStore(cfg)
+	appTokens := twitch.NewAppTokenManager(cfg, store)
+	if err := appTokens.EnsureValid(ctx); err != nil {
 		log.Fatalf("Error initializing Twitch app access token: %v", err)
 	}
+	go appTokens.Run(ctx)
+	go appTokens.RunValidation(ctx)
+
+	accounts, err := twitch.NewAccounts(ctx, cfg, store)
+	if err != nil {
+		log.Fatalf("Error loading accounts: %v", err)
+	}
+	accounts.Run(ctx)
 
-	go twitch.BotCommands()
+	helix := twitch.NewClient(cfg, nil)
+	helix.AppToken = appTokens
+	helix.UserToken = accounts.Source()
 
-	app := server.New(cfg)
+	bus := events.NewBus()
+	chat := twitch.NewIRCPool(twitch.NewChatAuth(accounts), bus)
+	chat.Emotes = newEmoteResolver(cfg, helix)
+	go twitch.BotCommands(chat)
+	webhook := twitch.NewEventSubWebhook(cfg, helix, accounts, bus)
+	if webhook.Callback != "" {
+		go webhook.Run(ctx)
+	} else {
+		go twitch.NewEventSubWS(cfg, helix, accounts, bus).Run(ctx)
+	}
+
+	app := server.New(cfg, server.Services{
+		Helix:     helix,
+		AppTokens: appTokens,
+		Accounts:  accounts,
+		Chat:      chat,
+		Sender:    twitch.NewChatSender(cfg, chat, helix),
+		Bus:       bus,
+		EventSub:  webhook,
+	})
 	log.Fatal(app.Listen(":" + cfg.Port))
 }
+
+// newEmoteResolver returns the resolver for the configured emote providers,
+// taking cheermotes from Helix.
+func newEmoteResolver(cfg config.Config, helix *twitch.Client) *emotes.Resolver {
+	providers, err := emotes.NewProviders(cfg.EmoteProviders, nil)
+	if err != nil {
+		log.Fatalf("Error configuring emote providers: %v", err)
+	}
+	cheermotes := func(ctx context.Context, channelID string) ([]emotes.Cheermote, error) {
+		res, err := helix.GetCheermotes(ctx, channelID)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]emotes.Cheermote, 0, len(res.Data))
+		for _, c := range res.Data {
+			cm := emotes.Cheermote{Prefix: c.Prefix}
+			for _, t := range c.Tiers {
+				cm.Tiers = append(cm.Tiers, t.MinBits)
+			}
+			list = append(list, cm)
+		}
+		return list, nil
+	}
+	return emotes.NewResolver(providers, cheermotes, cfg.EmoteCacheTTL)
+}
+
+// openTokenStore opens the configured token store and moves tokens left in
+// .env by earlier versions into it.
+func openTokenStore(cfg config.Config) twitch.TokenStore {
+	store, err := twitch.OpenTokenStore(cfg)
+	if err != nil {
+		log.Fatalf("Error opening token store: %v", err)
+	}
+	if err := twitch.MigrateEnvTokens(store); err != nil {
+		log.Fatalf("Error migrating tokens from .env: %v", err)
+	}
+	return store
+}
@@ -4,15 +4,28 @@ import (
 	"net/http"
 
 	"go-twitch/config"
+	"go-twitch/events"
 	"go-twitch/handlers"
+	"go-twitch/twitch"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 )
 
+// Services bundles the long-lived components the routes depend on.
+type Services struct {
+	Helix     *twitch.Client
+	AppTokens *twitch.TokenManager
+	Accounts  *twitch.Accounts
+	Chat      *twitch.IRCPool
+	Sender    *twitch.ChatSender
+	Bus       *events.Bus
+	EventSub  *twitch.EventSubWebhook
+}
+
 // New creates and configures the Fiber app with routes and middleware.
-func New(cfg config.Config) *fiber.App {
+func New(cfg config.Config, svc Services) *fiber.App {
 	app := fiber.New()
 
 	// Static files
@@ -26,28 +39,38 @@ func New(cfg config.Config) *fiber.App {
 	})
 
 	// REST endpoints
-	app.Get("/user/:name", handlers.GetUser)
-	app.Get("/stream/:name", handlers.GetStream)
-	app.Get("/games/top", handlers.GetTopGames)
+	app.Get("/user/:name", handlers.GetUser(svc.Helix))
+	app.Get("/stream/:name", handlers.GetStream(svc.Helix))
+	app.Get("/games/top", handlers.GetTopGames(svc.Helix))
 
 	// OAuth endpoints
 	app.Get("/authorize", handlers.AuthorizePage)
-	app.Get("/auth/start", handlers.AuthStart)
-	app.Get("/auth/callback", handlers.AuthCallback)
-	app.Get("/auth/status", handlers.AuthStatus)
+	states := handlers.NewOAuthStates(cfg.SessionSecret)
+	app.Get("/auth/start", handlers.AuthStart(cfg, svc.Accounts, states))
+	app.Get("/auth/callback", handlers.AuthCallback(cfg, svc.Accounts, states))
+	devices := handlers.NewDeviceFlows()
+	app.Get("/auth/device", handlers.DevicePage)
+	app.Post("/auth/device/start", handlers.DeviceStart(cfg, devices))
+	app.Get("/auth/device/poll", handlers.DevicePoll(cfg, svc.Accounts, devices))
+	app.Post("/auth/revoke", handlers.AuthRevoke(cfg, svc.AppTokens, svc.Accounts))
+	app.Get("/auth/status", handlers.AuthStatus(cfg, svc.AppTokens, svc.Accounts))
 	app.Get("/callback", handlers.CallbackAlias)
 
 	// IRC endpoints
-	app.Post("/irc/subscribe", handlers.IRCSubscribe)
-	app.Post("/irc/subscribe/:channel", handlers.IRCSubscribeParam)
-	app.Get("/irc/subscribe/:channel", handlers.IRCSubscribeParamHTML)
-	app.Post("/irc/unsubscribe", handlers.IRCUnsubscribe)
-	app.Get("/irc/unsubscribe/:channel", handlers.IRCUnsubscribeParamHTML)
-	app.Post("/irc/send", handlers.IRCSend)
+	app.Post("/irc/subscribe", handlers.IRCSubscribe(svc.Chat))
+	app.Post("/irc/subscribe/:channel", handlers.IRCSubscribeParam(svc.Chat))
+	app.Get("/irc/subscribe/:channel", handlers.IRCSubscribeParamHTML(svc.Chat))
+	app.Post("/irc/unsubscribe", handlers.IRCUnsubscribe(svc.Chat))
+	app.Get("/irc/unsubscribe/:channel", handlers.IRCUnsubscribeParamHTML(svc.Chat))
+	app.Post("/irc/send", handlers.IRCSend(svc.Sender))
+	app.Get("/irc/queue", handlers.IRCQueue(svc.Chat))
+
+	// EventSub webhook transport
+	app.Post("/eventsub/callback", handlers.EventSubCallback(svc.EventSub))
 
 	// WebSocket and SSE
-	app.Get("/ws", websocket.New(WebsocketHandler))
-	app.Get("/irc/:channel/stream", SSEChannelStream)
+	app.Get("/ws", websocket.New(WebsocketHandler(svc.Chat, svc.Sender)))
+	app.Get("/irc/:channel/stream", SSEChannelStream(svc.Chat))
 
 	return app
 }
@@ -3,73 +3,88 @@ package server
 import (
 	"bufio"
 	"encoding/json"
-	"os"
 	"time"
 
+	"go-twitch/events"
 	"go-twitch/twitch"
 
-	irc "github.com/gempir/go-twitch-irc/v4"
 	"github.com/gofiber/fiber/v2"
 	"github.com/valyala/fasthttp"
 )
 
-// SSEChannelStream streams IRC messages via Server-Sent Events
-func SSEChannelStream(c *fiber.Ctx) error {
-	channel := c.Params("channel")
-	if channel == "" {
-		return c.Status(400).SendString("Missing channel name")
-	}
-	c.Set("Content-Type", "text/event-stream")
-	c.Set("Cache-Control", "no-cache")
-	c.Set("Connection", "keep-alive")
-	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
-		msgChan := make(chan map[string]interface{}, 100)
-		quit := make(chan struct{})
+const sseHeartbeat = 15 * time.Second
 
-		go twitch.StartIRCRelay(channel)
-
-		botUsername := os.Getenv("TWITCH_BOT_USERNAME")
-		botToken := os.Getenv("TWITCH_USER_ACCESS_TOKEN")
-		if botUsername == "" || botToken == "" {
-			// Notify client once and stop stream
-			errMsg := map[string]string{"error": "Bot credentials missing. Set TWITCH_BOT_USERNAME and TWITCH_USER_ACCESS_TOKEN."}
-			jsonMsg, _ := json.Marshal(errMsg)
-			w.WriteString("data: ")
-			w.Write(jsonMsg)
-			w.WriteString("\n\n")
-			w.Flush()
-			return
+// SSEChannelStream streams IRC messages via Server-Sent Events
+func SSEChannelStream(pool *twitch.IRCPool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		channel := c.Params("channel")
+		if channel == "" {
+			return c.Status(400).SendString("Missing channel name")
 		}
-		ircClient := irc.NewClient(botUsername, "oauth:"+botToken)
-		ircClient.Capabilities = []string{irc.TagsCapability, irc.CommandsCapability, irc.MembershipCapability}
-		ircClient.OnPrivateMessage(func(m irc.PrivateMessage) {
-			if m.Channel == channel {
-				msg := map[string]interface{}{
-					"user":      m.User.Name,
-					"message":   m.Message,
-					"channel":   m.Channel,
-					"timestamp": time.Now().UTC().Format(time.RFC3339),
-				}
-				msgChan <- msg
-			}
-		})
-		go func() {
-			ircClient.Join(channel)
-			ircClient.Connect()
-		}()
-
-		for {
-			select {
-			case msg := <-msgChan:
-				jsonMsg, _ := json.Marshal(msg)
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+			sub, err := pool.Subscribe(channel)
+			if err != nil {
+				// Notify client once and stop stream
+				errMsg := map[string]string{"error": err.Error()}
+				jsonMsg, _ := json.Marshal(errMsg)
 				w.WriteString("data: ")
 				w.Write(jsonMsg)
 				w.WriteString("\n\n")
 				w.Flush()
-			case <-quit:
 				return
 			}
-		}
-	}))
-	return nil
+			defer sub.Close()
+
+			// Comments keep idle streams alive and reveal clients that went
+			// away, so their subscription is released.
+			heartbeat := time.NewTicker(sseHeartbeat)
+			defer heartbeat.Stop()
+			for {
+				select {
+				case <-heartbeat.C:
+					w.WriteString(": ping\n\n")
+				case e, ok := <-sub.C:
+					if !ok {
+						return
+					}
+					// Chat messages are unnamed events; USERNOTICEs are
+					// named after their type and the other events after
+					// theirs.
+					name := ""
+					switch m := e.(type) {
+					case events.ChatMessage:
+					case events.UserNoticeEvent:
+						name = m.Notice().Type
+					case events.Notice:
+						name = "notice"
+					case events.ClearChat:
+						name = "clearchat"
+					case events.ClearMsg:
+						name = "clearmsg"
+					case events.RoomState:
+						name = "roomstate"
+					case events.EventSubNotification:
+						name = "eventsub"
+					default:
+						continue
+					}
+					jsonMsg, _ := json.Marshal(e)
+					if name != "" {
+						w.WriteString("event: " + name + "\n")
+					}
+					w.WriteString("data: ")
+					w.Write(jsonMsg)
+					w.WriteString("\n\n")
+				}
+				// A failed flush means the client went away.
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}))
+		return nil
+	}
 }
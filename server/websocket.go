@@ -1,182 +1,228 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
-	"os"
+	"sync"
+	"time"
+
+	"go-twitch/events"
+	"go-twitch/twitch"
 
-	irc "github.com/gempir/go-twitch-irc/v4"
 	"github.com/gofiber/contrib/websocket"
 )
 
+// wsPrefs are the per-connection event preferences (defaults enabled).
+type wsPrefs struct {
+	mu         sync.Mutex
+	Notice     bool
+	UserNotice bool
+	ClearChat  bool
+	ClearMsg   bool
+	RoomState  bool
+}
+
+// wsSendTimeout bounds how long a send action waits for Twitch to confirm
+// the message.
+const wsSendTimeout = 30 * time.Second
+
 // WebsocketHandler handles /ws chat relay
-func WebsocketHandler(c *websocket.Conn) {
-	defer c.Close()
-	monitored := make(map[string]*irc.Client)
-	msgChan := make(chan []byte, 100)
-	quit := make(chan struct{})
+func WebsocketHandler(pool *twitch.IRCPool, sender *twitch.ChatSender) func(*websocket.Conn) {
+	return func(c *websocket.Conn) {
+		defer c.Close()
+		monitored := make(map[string]*twitch.IRCSubscription)
+		msgChan := make(chan []byte, 100)
+		// relays tracks every goroutine writing to msgChan.
+		var relays sync.WaitGroup
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-	// Per-connection event preferences (defaults enabled)
-	prefs := struct {
-		Notice     bool
-		UserNotice bool
-		ClearChat  bool
-		RoomState  bool
-	}{Notice: true, UserNotice: true, ClearChat: true, RoomState: true}
+		prefs := &wsPrefs{Notice: true, UserNotice: true, ClearChat: true, ClearMsg: true, RoomState: true}
 
-	go func() {
-		for msg := range msgChan {
-			c.WriteMessage(websocket.TextMessage, msg)
-		}
-	}()
+		go func() {
+			for msg := range msgChan {
+				c.WriteMessage(websocket.TextMessage, msg)
+			}
+		}()
 
-	for {
-		msgType, msg, err := c.ReadMessage()
-		if err != nil {
-			log.Printf("WebSocket read error: %v", err)
-			break
-		}
-		if msgType == websocket.TextMessage {
-			var cmd struct {
-				Action  string          `json:"action"`
-				Channel string          `json:"channel"`
-				Prefs   map[string]bool `json:"prefs"`
+		for {
+			msgType, msg, err := c.ReadMessage()
+			if err != nil {
+				log.Printf("WebSocket read error: %v", err)
+				break
 			}
-			if err := json.Unmarshal(msg, &cmd); err == nil {
-				switch cmd.Action {
-				case "subscribe":
-					if _, ok := monitored[cmd.Channel]; ok {
-						continue
-					}
-					botUsername := os.Getenv("TWITCH_BOT_USERNAME")
-					botToken := os.Getenv("TWITCH_USER_ACCESS_TOKEN")
-					ircClient := irc.NewClient(botUsername, "oauth:"+botToken)
-					ircClient.Capabilities = []string{irc.TagsCapability, irc.CommandsCapability, irc.MembershipCapability}
-					ircClient.OnPrivateMessage(func(m irc.PrivateMessage) {
-						if m.Channel == cmd.Channel {
-							chatMsg := map[string]interface{}{
-								"user":    m.User.Name,
-								"message": m.Message,
-								"channel": m.Channel,
-							}
-							jsonMsg, _ := json.Marshal(chatMsg)
+			if msgType == websocket.TextMessage {
+				var cmd struct {
+					Action  string          `json:"action"`
+					Channel string          `json:"channel"`
+					Prefs   map[string]bool `json:"prefs"`
+					// Send fields; ID is echoed in the result.
+					ID        string `json:"id"`
+					Message   string `json:"message"`
+					ReplyTo   string `json:"reply_to"`
+					Transport string `json:"transport"`
+				}
+				if err := json.Unmarshal(msg, &cmd); err == nil {
+					switch cmd.Action {
+					case "subscribe":
+						if _, ok := monitored[cmd.Channel]; ok {
+							continue
+						}
+						sub, err := pool.Subscribe(cmd.Channel)
+						if err != nil {
+							errBytes, _ := json.Marshal(map[string]interface{}{
+								"type":    "error",
+								"channel": cmd.Channel,
+								"error":   err.Error(),
+							})
 							select {
-							case msgChan <- jsonMsg:
+							case msgChan <- errBytes:
 							default:
 							}
+							continue
 						}
-					})
-					// Relay generic NOTICE messages
-					ircClient.OnNoticeMessage(func(m irc.NoticeMessage) {
-						if m.Channel == cmd.Channel {
-							if prefs.Notice {
-								notice := map[string]interface{}{
-									"type":    "notice",
-									"channel": m.Channel,
-									"system":  m.Message,
-								}
-								jsonMsg, _ := json.Marshal(notice)
-								select {
-								case msgChan <- jsonMsg:
-								default:
-								}
-							}
+						monitored[cmd.Channel] = sub
+						relays.Add(1)
+						go func() {
+							defer relays.Done()
+							relayWebsocket(sub, prefs, msgChan)
+						}()
+						// send subscription acknowledgement to the client
+						ack := map[string]interface{}{
+							"type":    "subscribed",
+							"channel": cmd.Channel,
 						}
-					})
-					// Relay USERNOTICE events (subs, resubs, gifts, raids, etc.)
-					ircClient.OnUserNoticeMessage(func(m irc.UserNoticeMessage) {
-						if m.Channel == cmd.Channel {
-							if prefs.UserNotice {
-								notice := map[string]interface{}{
-									"type":    "usernotice",
-									"channel": m.Channel,
-									"system":  m.SystemMsg,
-									"msg_id":  m.MsgID,
-								}
-								jsonMsg, _ := json.Marshal(notice)
-								select {
-								case msgChan <- jsonMsg:
-								default:
-								}
-							}
+						ackBytes, _ := json.Marshal(ack)
+						select {
+						case msgChan <- ackBytes:
+						default:
 						}
-					})
-					// Relay CLEARCHAT (timeouts/bans)
-					ircClient.OnClearChatMessage(func(m irc.ClearChatMessage) {
-						if m.Channel == cmd.Channel {
-							if prefs.ClearChat {
-								payload := map[string]interface{}{
-									"type":    "clearchat",
-									"channel": m.Channel,
-								}
-								jsonMsg, _ := json.Marshal(payload)
-								select {
-								case msgChan <- jsonMsg:
-								default:
-								}
+					case "send":
+						transport, err := twitch.ParseChatTransport(cmd.Transport)
+						if err != nil || cmd.Channel == "" || cmd.Message == "" {
+							if err == nil {
+								err = errors.New("missing channel or message")
 							}
-						}
-					})
-					// Relay ROOMSTATE changes (slow mode, emote-only, etc.)
-					ircClient.OnRoomStateMessage(func(m irc.RoomStateMessage) {
-						if m.Channel == cmd.Channel {
-							if prefs.RoomState {
-								payload := map[string]interface{}{
-									"type":    "roomstate",
-									"channel": m.Channel,
-								}
-								jsonMsg, _ := json.Marshal(payload)
-								select {
-								case msgChan <- jsonMsg:
-								default:
-								}
+							errBytes, _ := json.Marshal(map[string]interface{}{
+								"type":    "error",
+								"id":      cmd.ID,
+								"channel": cmd.Channel,
+								"error":   err.Error(),
+							})
+							select {
+							case msgChan <- errBytes:
+							default:
 							}
+							continue
 						}
-					})
-					monitored[cmd.Channel] = ircClient
-					go func(channel string) {
-						ircClient.Join(channel)
-						if err := ircClient.Connect(); err != nil {
-							log.Printf("IRC client connection error for channel %s: %v", channel, err)
-						}
-					}(cmd.Channel)
-					// send subscription acknowledgement to the client
-					ack := map[string]interface{}{
-						"type":    "subscribed",
-						"channel": cmd.Channel,
-					}
-					ackBytes, _ := json.Marshal(ack)
-					select {
-					case msgChan <- ackBytes:
-					default:
-					}
-				case "unsubscribe":
-					if ircClient, ok := monitored[cmd.Channel]; ok {
-						ircClient.Disconnect()
-						delete(monitored, cmd.Channel)
-					}
-				case "setPreferences":
-					if cmd.Prefs != nil {
-						if v, ok := cmd.Prefs["notice"]; ok {
-							prefs.Notice = v
-						}
-						if v, ok := cmd.Prefs["usernotice"]; ok {
-							prefs.UserNotice = v
-						}
-						if v, ok := cmd.Prefs["clearchat"]; ok {
-							prefs.ClearChat = v
+						relays.Add(1)
+						go func() {
+							defer relays.Done()
+							sendCtx, cancel := context.WithTimeout(ctx, wsSendTimeout)
+							defer cancel()
+							payload := map[string]interface{}{"type": "sent", "id": cmd.ID, "channel": cmd.Channel}
+							if result, err := sender.Send(sendCtx, cmd.Channel, cmd.Message, cmd.ReplyTo, transport); err != nil {
+								payload["type"], payload["error"] = "error", err.Error()
+							} else {
+								payload["result"] = result
+							}
+							resBytes, _ := json.Marshal(payload)
+							select {
+							case msgChan <- resBytes:
+							default:
+							}
+						}()
+					case "unsubscribe":
+						if sub, ok := monitored[cmd.Channel]; ok {
+							sub.Close()
+							delete(monitored, cmd.Channel)
 						}
-						if v, ok := cmd.Prefs["roomstate"]; ok {
-							prefs.RoomState = v
+					case "setPreferences":
+						if cmd.Prefs != nil {
+							prefs.mu.Lock()
+							if v, ok := cmd.Prefs["notice"]; ok {
+								prefs.Notice = v
+							}
+							if v, ok := cmd.Prefs["usernotice"]; ok {
+								prefs.UserNotice = v
+							}
+							if v, ok := cmd.Prefs["clearchat"]; ok {
+								prefs.ClearChat = v
+							}
+							if v, ok := cmd.Prefs["clearmsg"]; ok {
+								prefs.ClearMsg = v
+							}
+							if v, ok := cmd.Prefs["roomstate"]; ok {
+								prefs.RoomState = v
+							}
+							prefs.mu.Unlock()
 						}
 					}
 				}
 			}
 		}
+		for _, sub := range monitored {
+			sub.Close()
+		}
+		cancel()
+		relays.Wait()
+		close(msgChan)
 	}
-	for _, ircClient := range monitored {
-		ircClient.Disconnect()
+}
+
+// relayWebsocket forwards the events of sub to msgChan until sub is closed.
+func relayWebsocket(sub *twitch.IRCSubscription, prefs *wsPrefs, msgChan chan<- []byte) {
+	for e := range sub.C {
+		var payload interface{}
+		prefs.mu.Lock()
+		switch m := e.(type) {
+		// Chat messages use the versioned chat message schema
+		case events.ChatMessage:
+			payload = m
+		// Relay generic NOTICE messages
+		case events.Notice:
+			if prefs.Notice {
+				payload = m
+			}
+		// Relay USERNOTICE events (subs, resubs, gifts, raids, etc.),
+		// typed by their own "type" field
+		case events.UserNoticeEvent:
+			if prefs.UserNotice {
+				payload = m
+			}
+		// Relay CLEARCHAT (timeouts/bans)
+		case events.ClearChat:
+			if prefs.ClearChat {
+				payload = m
+			}
+		// Relay CLEARMSG (single deleted messages)
+		case events.ClearMsg:
+			if prefs.ClearMsg {
+				payload = m
+			}
+		// Relay ROOMSTATE changes (slow mode, emote-only, etc.)
+		case events.RoomState:
+			if prefs.RoomState {
+				payload = m
+			}
+		// Relay EventSub notifications for the channel
+		case events.EventSubNotification:
+			payload = map[string]interface{}{
+				"type":    "eventsub",
+				"channel": m.Channel,
+				"event":   m,
+			}
+		}
+		prefs.mu.Unlock()
+		if payload == nil {
+			continue
+		}
+		jsonMsg, _ := json.Marshal(payload)
+		select {
+		case msgChan <- jsonMsg:
+		default:
+		}
 	}
-	close(msgChan)
-	close(quit)
 }
@@ -198,12 +198,12 @@ function addNoticeEntry(channel, kind, text) {
   item.innerHTML = `
     <div style="display:flex;justify-content:space-between;align-items:center;margin-bottom:4px;">
       <div style="display:flex;gap:6px;align-items:center;">
-        <span style="display:inline-block;padding:2px 6px;border-radius:10px;background:#21262d;color:#c9d1d9;font-size:0.7rem;text-transform:uppercase;">${kind}</span>
-        <span class="data-label">#${channel}</span>
+        <span style="display:inline-block;padding:2px 6px;border-radius:10px;background:#21262d;color:#c9d1d9;font-size:0.7rem;text-transform:uppercase;">${escapeHtml(kind)}</span>
+        <span class="data-label">#${escapeHtml(channel)}</span>
       </div>
       <span style="color:#7d8590;font-size:0.75rem;">${when}</span>
     </div>
-    <div class="data-value">${text}</div>
+    <div class="data-value">${escapeHtml(text)}</div>
   `;
   // prepend new entries so latest is visible without scrolling
   if (list.firstChild) {
@@ -218,12 +218,19 @@ function addNoticeEntry(channel, kind, text) {
   const count = document.getElementById('ircNoticesCount');
   if (count) count.textContent = String(list.children.length);
 }
+// escapeHtml makes chat-controlled text safe to place in HTML text and
+// attribute values.
+function escapeHtml(text) {
+  const entities = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' };
+  return String(text ?? '').replace(/[&<>"']/g, c => entities[c]);
+}
 function updateIrcPrefs() {
   if (!ws || ws.readyState !== WebSocket.OPEN) return;
   const prefs = {
     notice: document.getElementById('prefNotice')?.checked ?? true,
     usernotice: document.getElementById('prefUserNotice')?.checked ?? true,
     clearchat: document.getElementById('prefClearChat')?.checked ?? true,
+    clearmsg: document.getElementById('prefClearMsg')?.checked ?? true,
     roomstate: document.getElementById('prefRoomState')?.checked ?? true,
   };
   ws.send(JSON.stringify({ action: 'setPreferences', prefs }));
@@ -245,7 +252,7 @@ ws.onmessage = (event) => {
   } catch (e) {
     return;
   }
-  if (data.user && data.message && data.channel) {
+  if (data.type === 'chat_message') {
     // Only show messages from monitored channels
     if (!monitoredChannels.includes(data.channel)) return;
     const chatContainer = document.getElementById('ircChatMessages');
@@ -256,13 +263,26 @@ ws.onmessage = (event) => {
     }
     const messageDiv = document.createElement('div');
     messageDiv.className = 'chat-message';
+    const replyLine = data.reply
+      ? `<div class="chat-reply">↪ @${escapeHtml(data.reply.parent_user_login)}: ${escapeHtml(data.reply.parent_msg_body)}</div>`
+      : '';
+    // Only plain hex colors may go into the style attribute.
+    const color = /^#[0-9a-fA-F]{6}$/.test(data.color || '') ? data.color : '';
     messageDiv.innerHTML = `
+      ${replyLine}
       <div class="chat-meta">
-        <span class="chat-user">${data.user}</span>
-        <span class="chat-channel">#${data.channel}</span>
+        <span class="chat-user"${color ? ` style="color: ${color}"` : ''}>${escapeHtml(data.display_name || data.user)}</span>
+        <span class="chat-channel">#${escapeHtml(data.channel)}</span>
       </div>
-      <div class="chat-text">${data.message}</div>
+      <div class="chat-text">${renderFragments(data)}</div>
     `;
+    if (data.id) {
+      const replyBtn = document.createElement('button');
+      replyBtn.className = 'chat-reply-btn';
+      replyBtn.textContent = 'Reply';
+      replyBtn.onclick = () => startReply(data.channel, data.id, data.user);
+      messageDiv.querySelector('.chat-meta').appendChild(replyBtn);
+    }
     chatContainer.appendChild(messageDiv);
     chatContainer.scrollTop = chatContainer.scrollHeight;
     // Keep only last 100 messages
@@ -272,20 +292,28 @@ ws.onmessage = (event) => {
   }
   // Render USERNOTICE and other room notices into results (only for monitored channels)
   if (data.type === 'notice' && data.channel && monitoredChannels.includes(data.channel)) {
-    addNoticeEntry(data.channel, 'notice', data.system || 'Notification');
+    addNoticeEntry(data.channel, 'notice', data.message || 'Notification');
   }
-  if (data.type === 'usernotice' && data.channel && monitoredChannels.includes(data.channel)) {
-    addNoticeEntry(data.channel, 'usernotice', data.system || 'User notice');
+  if (userNoticeTypes.includes(data.type) && data.channel && monitoredChannels.includes(data.channel)) {
+    addNoticeEntry(data.channel, data.type, data.system_msg || 'User notice');
   }
   // Subscription acknowledgement
   if (data.type === 'subscribed' && data.channel) {
     addNoticeEntry(data.channel, 'joined', `Now monitoring #${data.channel}. Room notices will appear here.`);
   }
   if (data.type === 'clearchat' && data.channel && monitoredChannels.includes(data.channel)) {
-    addNoticeEntry(data.channel, 'clearchat', 'Chat cleared / moderation action triggered');
+    addNoticeEntry(data.channel, 'clearchat', describeClearChat(data));
+  }
+  if (data.type === 'clearmsg' && data.channel && monitoredChannels.includes(data.channel)) {
+    addNoticeEntry(data.channel, 'clearmsg', `Deleted message from ${data.user}: ${data.message}`);
   }
   if (data.type === 'roomstate' && data.channel && monitoredChannels.includes(data.channel)) {
-    addNoticeEntry(data.channel, 'roomstate', 'Room state changed');
+    addNoticeEntry(data.channel, 'roomstate', describeRoomState(data));
+  }
+  if (data.type === 'eventsub' && data.channel && monitoredChannels.includes(data.channel)) {
+    const ev = data.event.event || {};
+    const who = ev.user_name || ev.from_broadcaster_user_name || '';
+    addNoticeEntry(data.channel, 'eventsub', who ? `${data.event.type}: ${who}` : data.event.type);
   }
 };
 ws.onclose = () => {
@@ -380,6 +408,82 @@ function unsubscribeFromIRCChat(channel) {
     updateChatStatus(`Monitoring ${monitoredChannels.map(c => `#${c}`).join(' , ')}`, true);
   }
 }
+// USERNOTICE event types; see the events package.
+const userNoticeTypes = ['sub', 'resub', 'subgift', 'submysterygift', 'giftpaidupgrade', 'raid', 'announcement', 'bitsbadgetier', 'usernotice'];
+
+function describeClearChat(data) {
+  if (data.action === 'ban') return `${data.target_user} was banned`;
+  if (data.action === 'timeout') return `${data.target_user} was timed out for ${data.ban_duration}s`;
+  return 'Chat was cleared';
+}
+
+function describeRoomState(data) {
+  const s = data.settings || {};
+  const labels = {
+    emote_only: () => `emote-only ${s.emote_only ? 'on' : 'off'}`,
+    followers_only: () => s.followers_only < 0 ? 'followers-only off' : `followers-only ${s.followers_only}m`,
+    r9k: () => `unique chat ${s.r9k ? 'on' : 'off'}`,
+    slow: () => s.slow > 0 ? `slow mode ${s.slow}s` : 'slow mode off',
+    subs_only: () => `subs-only ${s.subs_only ? 'on' : 'off'}`,
+  };
+  const changed = (data.changed && data.changed.length) ? data.changed : Object.keys(labels);
+  return changed.map(k => labels[k] ? labels[k]() : k).join(', ');
+}
+
+// renderFragments renders a chat message from its server-side fragments.
+function renderFragments(data) {
+  if (!data.fragments) return escapeHtml(data.message);
+  return data.fragments.map(f => {
+    const text = escapeHtml(f.text);
+    switch (f.type) {
+      case 'emote': {
+        const src = safeUrl(f.emote && f.emote.url);
+        if (!src) return text;
+        return `<img class="chat-emote" src="${escapeHtml(src)}" alt="${text}" title="${text} (${escapeHtml(f.emote.provider)})">`;
+      }
+      case 'cheermote':
+        return `<span class="chat-cheer">${text}</span>`;
+      case 'mention':
+        return `<span class="chat-mention">${text}</span>`;
+      case 'url': {
+        const href = safeUrl(f.url);
+        if (!href) return text;
+        return `<a href="${escapeHtml(href)}" target="_blank" rel="noopener noreferrer">${text}</a>`;
+      }
+      default:
+        return text;
+    }
+  }).join('');
+}
+
+// safeUrl returns url if it is an http or https URL, else an empty string,
+// so chat cannot smuggle javascript: or data: links into the page.
+function safeUrl(url) {
+  try {
+    const u = new URL(url);
+    return u.protocol === 'http:' || u.protocol === 'https:' ? u.href : '';
+  } catch (e) {
+    return '';
+  }
+}
+
+// Message the next sent message replies to, if any.
+let replyTarget = null;
+
+function startReply(channel, id, user) {
+  replyTarget = { channel, id };
+  document.getElementById('chatSendChannel').value = channel;
+  const indicator = document.getElementById('chatReplyTo');
+  indicator.textContent = `Replying to @${user} (click to cancel)`;
+  indicator.style.display = 'block';
+  document.getElementById('chatSendInput').focus();
+}
+
+function cancelReply() {
+  replyTarget = null;
+  document.getElementById('chatReplyTo').style.display = 'none';
+}
+
 function sendChatMessage(event) {
   event.preventDefault();
   const channel = document.getElementById('chatSendChannel').value;
@@ -397,7 +501,11 @@ function sendChatMessage(event) {
   fetch('/irc/send', {
     method: 'POST',
     headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ channel, message })
+    body: JSON.stringify({
+      channel,
+      message,
+      reply_to: replyTarget && replyTarget.channel === channel ? replyTarget.id : undefined
+    })
   })
     .then(res => res.json())
     .then(data => {
@@ -405,6 +513,7 @@ function sendChatMessage(event) {
         alert('Failed to send: ' + data.message);
       } else {
         input.value = '';
+        cancelReply();
       }
     })
     .catch(() => alert('Failed to send message'))
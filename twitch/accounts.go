@@ -0,0 +1,333 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"go-twitch/config"
+)
+
+// Role is what an authorized account is used for.
+type Role string
+
+const (
+	// RoleBot accounts send chat messages and run bot commands.
+	RoleBot Role = "bot"
+	// RoleBroadcaster accounts own a channel and authenticate Helix calls
+	// about it.
+	RoleBroadcaster Role = "broadcaster"
+	// RoleModerator accounts moderate channels they do not own.
+	RoleModerator Role = "moderator"
+)
+
+// ParseRole parses a role name; empty means RoleBroadcaster.
+func ParseRole(s string) (Role, error) {
+	switch r := Role(strings.ToLower(strings.TrimSpace(s))); r {
+	case "":
+		return RoleBroadcaster, nil
+	case RoleBot, RoleBroadcaster, RoleModerator:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown role %q (want bot, broadcaster or moderator)", s)
+	}
+}
+
+// userTokenPrefix prefixes the store key of every user account.
+const userTokenPrefix = "user:"
+
+// Accounts holds the user tokens of every authorized Twitch account, keyed by
+// user ID, and picks the account to act as for a channel. It is safe for
+// concurrent use.
+type Accounts struct {
+	cfg   config.Config
+	store TokenStore
+
+	mu       sync.RWMutex
+	managers map[string]*TokenManager
+	// stops cancels the background renewal and validation of each manager.
+	stops map[*TokenManager]context.CancelFunc
+	// removed are called with every account that is removed.
+	removed []func(*TokenManager)
+	// ctx is set by Run; managers added later are kept fresh under it.
+	ctx context.Context
+}
+
+// NewAccounts loads the accounts saved in store. A single-user token saved by
+// an earlier version is moved under its user ID, with the bot and
+// broadcaster roles it used to fill.
+func NewAccounts(ctx context.Context, cfg config.Config, store TokenStore) (*Accounts, error) {
+	a := &Accounts{
+		cfg:      cfg,
+		store:    store,
+		managers: make(map[string]*TokenManager),
+		stops:    make(map[*TokenManager]context.CancelFunc),
+	}
+	keys, err := store.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, userTokenPrefix) {
+			continue
+		}
+		t := loadToken(store, key)
+		if t.AccessToken == "" {
+			continue
+		}
+		a.managers[t.UserID] = a.newManager(t)
+	}
+	if legacy, ok, _ := store.Load(UserTokenKey); ok {
+		if err := a.migrate(ctx, legacy); err != nil {
+			log.Printf("[TOKEN] could not migrate the stored user token yet: %v", err)
+		}
+	}
+	return a, nil
+}
+
+func (a *Accounts) migrate(ctx context.Context, legacy Token) error {
+	m := NewTokenManager("user", legacy, func(ctx context.Context, current Token) (Token, error) {
+		return refreshUserToken(ctx, a.cfg, current)
+	}, nil)
+	if err := m.EnsureValid(ctx); err != nil {
+		return err
+	}
+	if _, err := a.Add(ctx, m.Current(), RoleBot, RoleBroadcaster); err != nil {
+		return err
+	}
+	log.Printf("[TOKEN] migrated the stored user token to a per-account token")
+	return a.store.Delete(UserTokenKey)
+}
+
+// newManager returns the TokenManager for an account. Refreshed tokens keep
+// the account's identity and roles.
+func (a *Accounts) newManager(t Token) *TokenManager {
+	refresh := func(ctx context.Context, current Token) (Token, error) {
+		next, err := refreshUserToken(ctx, a.cfg, current)
+		if err != nil {
+			return Token{}, err
+		}
+		next.UserID, next.Login, next.Roles = current.UserID, current.Login, current.Roles
+		return next, nil
+	}
+	key := userTokenPrefix + t.UserID
+	return NewTokenManager("user "+t.Login, t, refresh, persistTo(a.store, key))
+}
+
+// Add stores a freshly authorized user token for the given roles. The token
+// is validated to learn whose it is; if that account is already known its
+// roles are merged and its manager receives the new token.
+func (a *Accounts) Add(ctx context.Context, t Token, roles ...Role) (*TokenManager, error) {
+	v, err := ValidateToken(ctx, t.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify the authorized account: %w", err)
+	}
+	t.UserID, t.Login = v.UserID, v.Login
+	if len(t.Scopes) == 0 {
+		t.Scopes = v.Scopes
+	}
+
+	a.mu.Lock()
+	m, exists := a.managers[t.UserID]
+	if exists {
+		t.Roles = m.Current().Roles
+	}
+	for _, r := range roles {
+		if !slices.Contains(t.Roles, r) {
+			t.Roles = append(t.Roles, r)
+		}
+	}
+	if !exists {
+		m = a.newManager(t)
+		a.managers[t.UserID] = m
+	}
+	runCtx := a.ctx
+	a.mu.Unlock()
+
+	m.Set(t)
+	if !exists && runCtx != nil {
+		a.run(runCtx, m)
+	}
+	return m, nil
+}
+
+// Remove forgets an account and deletes its stored token. The manager is
+// cleared first so IRC clients logged in as it disconnect, then its
+// background renewal stops and the OnRemove callbacks release what they
+// kept for it.
+func (a *Accounts) Remove(userID string) {
+	a.mu.Lock()
+	m, ok := a.managers[userID]
+	delete(a.managers, userID)
+	stop := a.stops[m]
+	delete(a.stops, m)
+	removed := slices.Clone(a.removed)
+	a.mu.Unlock()
+	if !ok {
+		return
+	}
+	m.Clear()
+	if stop != nil {
+		stop()
+	}
+	for _, fn := range removed {
+		fn(m)
+	}
+}
+
+// OnRemove registers fn to be called with every account that is removed.
+func (a *Accounts) OnRemove(fn func(*TokenManager)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.removed = append(a.removed, fn)
+}
+
+// Get returns the account with the given user ID, or nil.
+func (a *Accounts) Get(userID string) *TokenManager {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.managers[userID]
+}
+
+// List returns every account, ordered by login.
+func (a *Accounts) List() []*TokenManager {
+	a.mu.RLock()
+	list := make([]*TokenManager, 0, len(a.managers))
+	for _, m := range a.managers {
+		list = append(list, m)
+	}
+	a.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Current().Login < list[j].Current().Login })
+	return list
+}
+
+// Run keeps every account's token renewed and validated until ctx is
+// cancelled, including accounts added later.
+func (a *Accounts) Run(ctx context.Context) {
+	a.mu.Lock()
+	a.ctx = ctx
+	managers := make([]*TokenManager, 0, len(a.managers))
+	for _, m := range a.managers {
+		managers = append(managers, m)
+	}
+	a.mu.Unlock()
+	for _, m := range managers {
+		if err := m.EnsureValid(ctx); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		a.run(ctx, m)
+	}
+}
+
+// run keeps m renewed and validated until ctx is cancelled or m is removed.
+func (a *Accounts) run(ctx context.Context, m *TokenManager) {
+	ctx, cancel := context.WithCancel(ctx)
+	a.mu.Lock()
+	if a.managers[m.Current().UserID] != m {
+		// Removed before it got to run.
+		a.mu.Unlock()
+		cancel()
+		return
+	}
+	a.stops[m] = cancel
+	a.mu.Unlock()
+	go m.Run(ctx)
+	go m.RunValidation(ctx)
+}
+
+// Default returns the account used when no channel is involved: the first
+// broadcaster, else the first account at all. It is nil without accounts.
+func (a *Accounts) Default() *TokenManager {
+	list := a.List()
+	for _, m := range list {
+		if slices.Contains(m.Current().Roles, RoleBroadcaster) {
+			return m
+		}
+	}
+	if len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+// ForChannel returns the account to act as in channel for role:
+//   - broadcaster: the account that owns channel.
+//   - moderator: the account assigned to channel in TWITCH_CHANNEL_ACCOUNTS,
+//     else the owner, else the first moderator account.
+//   - bot: the assigned account, else TWITCH_BOT_USERNAME, else the first
+//     bot account, else the owner.
+func (a *Accounts) ForChannel(channel string, role Role) (*TokenManager, error) {
+	channel = strings.ToLower(strings.TrimPrefix(channel, "#"))
+	list := a.List()
+	byLogin := func(login string) *TokenManager {
+		for _, m := range list {
+			if login != "" && strings.EqualFold(m.Current().Login, login) {
+				return m
+			}
+		}
+		return nil
+	}
+	withRole := func(r Role) *TokenManager {
+		for _, m := range list {
+			if slices.Contains(m.Current().Roles, r) {
+				return m
+			}
+		}
+		return nil
+	}
+
+	var candidates []*TokenManager
+	switch role {
+	case RoleBroadcaster:
+		candidates = []*TokenManager{byLogin(channel)}
+	case RoleModerator:
+		candidates = []*TokenManager{byLogin(a.cfg.ChannelAccounts[channel]), byLogin(channel), withRole(RoleModerator)}
+	default:
+		candidates = []*TokenManager{byLogin(a.cfg.ChannelAccounts[channel]), byLogin(a.cfg.BotUsername), withRole(RoleBot), byLogin(channel)}
+	}
+	for _, m := range candidates {
+		if m != nil {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s account authorized for channel %s; authorize one at /authorize", role, channel)
+}
+
+// Context returns ctx set up so Helix requests made with it act as the
+// account ForChannel picks for channel and role. Without such an account,
+// ctx is returned unchanged and the Client's default user token is used.
+func (a *Accounts) Context(ctx context.Context, channel string, role Role) context.Context {
+	m, err := a.ForChannel(channel, role)
+	if err != nil {
+		return ctx
+	}
+	return WithUserToken(ctx, m)
+}
+
+// Source returns a TokenSource that always resolves to the current Default
+// account, so a Client picks up accounts authorized after it was built.
+func (a *Accounts) Source() TokenSource {
+	return defaultAccount{a}
+}
+
+type defaultAccount struct{ a *Accounts }
+
+func (d defaultAccount) Token() (string, error) {
+	m := d.a.Default()
+	if m == nil {
+		return "", fmt.Errorf("no user account authorized")
+	}
+	return m.Token()
+}
+
+func (d defaultAccount) Invalidate(ctx context.Context, rejected string) (string, error) {
+	m := d.a.Default()
+	if m == nil {
+		return "", fmt.Errorf("no user account authorized")
+	}
+	return m.Invalidate(ctx, rejected)
+}
@@ -2,6 +2,7 @@
 package twitch
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -9,203 +10,212 @@ import (
 	"net/url"
 	"os"
 	"strings"
-
-	"bufio"
 	"time"
 
-	"github.com/gofiber/fiber/v2"
-	"github.com/joho/godotenv"
-)
-
-var (
-	RedirectURL = os.Getenv("TWITCH_REDIRECT_URI")
+	"go-twitch/config"
 )
 
-// In-memory storage for user access token and expiry
-type OAuthToken struct {
-	AccessToken string
-	ExpiresAt   time.Time
-}
-
-var oauthToken OAuthToken
-
-// UpdateEnvFile updates or adds a key-value pair in the .env file.
-func UpdateEnvFile(key, value string) error {
-	file, err := os.OpenFile(".env", os.O_RDWR|os.O_CREATE, 0644)
+// RemoveEnvKeys deletes the given keys from the .env file, leaving every
+// other line untouched. The file holds secrets, so it is rewritten
+// atomically and readable only by the owner.
+func RemoveEnvKeys(keys ...string) error {
+	data, err := os.ReadFile(".env")
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
 	var lines []string
-	found := false
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, key+"=") {
-			lines = append(lines, key+"="+value)
-			found = true
-		} else {
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		drop := false
+		for _, key := range keys {
+			if strings.HasPrefix(line, key+"=") {
+				drop = true
+				break
+			}
+		}
+		if !drop {
 			lines = append(lines, line)
 		}
 	}
-	if !found {
-		lines = append(lines, key+"="+value)
+	out := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		out += "\n"
 	}
-	file.Truncate(0)
-	file.Seek(0, 0)
-	for _, l := range lines {
-		file.WriteString(l + "\n")
+	if err := writeFileAtomic(".env", []byte(out)); err != nil {
+		return fmt.Errorf("failed to rewrite .env: %w", err)
 	}
 	return nil
 }
 
-// HandleTwitchAuth redirects the user to Twitch to authorize the application.
-func HandleTwitchAuth(w http.ResponseWriter, r *http.Request) {
-	scopes := []string{
-		"chat:read",
-		"chat:edit",
-		"user:read:email",
-	}
-	twitchClientID := os.Getenv("TWITCH_CLIENT_ID")
-	authURL := fmt.Sprintf("https://id.twitch.tv/oauth2/authorize?client_id=%s&redirect_uri=%s&response_type=code&scope=%s",
-		twitchClientID, url.QueryEscape(RedirectURL), url.QueryEscape(strings.Join(scopes, " ")))
-	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+// AuthorizeURL builds the Twitch authorization URL requesting scopes.
+func AuthorizeURL(cfg config.Config, scopes []string, state string) string {
+	return "https://id.twitch.tv/oauth2/authorize" +
+		"?client_id=" + url.QueryEscape(cfg.ClientID) +
+		"&redirect_uri=" + url.QueryEscape(cfg.RedirectURI) +
+		"&response_type=code" +
+		"&scope=" + url.QueryEscape(strings.Join(scopes, " ")) +
+		"&state=" + url.QueryEscape(state)
 }
 
-// HandleTwitchCallback handles the callback from Twitch after authorization.
-func HandleTwitchCallback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		http.Error(w, "Authorization code not found", http.StatusBadRequest)
-		return
+// NewAppTokenManager returns a TokenManager for the app access token. It is
+// seeded from the token saved in store, if any; call EnsureValid to fetch a
+// new one when that is missing or expired, and Run to keep it renewed.
+func NewAppTokenManager(cfg config.Config, store TokenStore) *TokenManager {
+	refresh := func(ctx context.Context, _ Token) (Token, error) {
+		return requestAppToken(ctx, cfg.ClientID, cfg.ClientSecret)
 	}
+	return NewTokenManager("app", loadToken(store, AppTokenKey), refresh, persistTo(store, AppTokenKey))
+}
 
-	// Removed token and EventSub logic
+const oauthTokenURL = "https://id.twitch.tv/oauth2/token"
 
-	http.Redirect(w, r, "/dashboard", http.StatusFound)
+// tokenResponse is the body of a successful id.twitch.tv/oauth2/token call.
+type tokenResponse struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Scope        []string `json:"scope"`
+	TokenType    string   `json:"token_type"`
 }
 
-// InitAppAccessToken fetches a Twitch app access token and stores it in the .env file
-func InitAppAccessToken() error {
-	clientID := os.Getenv("TWITCH_CLIENT_ID")
-	clientSecret := os.Getenv("TWITCH_CLIENT_SECRET")
-	if clientID == "" || clientSecret == "" {
-		return fmt.Errorf("TWITCH_CLIENT_ID or TWITCH_CLIENT_SECRET not set in environment")
+func (r tokenResponse) token() Token {
+	return Token{
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+		Scopes:       r.Scope,
+		ExpiresAt:    time.Now().Add(time.Duration(r.ExpiresIn) * time.Second),
 	}
+}
+
+// OAuthError is a non-200 reply from an id.twitch.tv/oauth2 endpoint.
+type OAuthError struct {
+	StatusCode int
+	// Message is Twitch's error message, e.g. "authorization_pending".
+	Message string
+	Body    string
+}
 
-	// Prepare request for client credentials flow
-	data := url.Values{}
-	data.Set("client_id", clientID)
-	data.Set("client_secret", clientSecret)
-	data.Set("grant_type", "client_credentials")
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("status: %d, body: %s", e.StatusCode, e.Body)
+}
 
-	resp, err := http.PostForm("https://id.twitch.tv/oauth2/token", data)
+// postOAuth posts form to an id.twitch.tv/oauth2 endpoint and decodes the
+// JSON reply into out.
+func postOAuth(ctx context.Context, endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
 	if err != nil {
-		return fmt.Errorf("failed to request app access token: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read app access token response: %w", err)
+		return fmt.Errorf("failed to read response: %w", err)
 	}
-
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to get app access token, status: %d, body: %s", resp.StatusCode, string(body))
+		oauthErr := &OAuthError{StatusCode: resp.StatusCode, Body: string(body)}
+		var reply struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &reply) == nil {
+			oauthErr.Message = reply.Message
+		}
+		return oauthErr
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
 	}
+	return nil
+}
 
-	var tokenResp struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
-		TokenType   string `json:"token_type"`
+// postToken posts form to the Twitch token endpoint and decodes the reply.
+func postToken(ctx context.Context, form url.Values) (*tokenResponse, error) {
+	var tokenResp tokenResponse
+	if err := postOAuth(ctx, oauthTokenURL, form, &tokenResp); err != nil {
+		return nil, err
 	}
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return fmt.Errorf("failed to unmarshal app access token: %w", err)
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("received empty access token from Twitch")
 	}
+	return &tokenResp, nil
+}
 
-	if tokenResp.AccessToken == "" {
-		return fmt.Errorf("received empty app access token from Twitch")
+// requestAppToken fetches a Twitch app access token via the client
+// credentials flow.
+func requestAppToken(ctx context.Context, clientID, clientSecret string) (Token, error) {
+	if clientID == "" || clientSecret == "" {
+		return Token{}, fmt.Errorf("TWITCH_CLIENT_ID or TWITCH_CLIENT_SECRET not set in environment")
+	}
+	resp, err := postToken(ctx, url.Values{
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"grant_type":    {"client_credentials"},
+	})
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to get app access token: %w", err)
 	}
+	return resp.token(), nil
+}
 
-	// Compute expiry timestamp
-	expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+const oauthRevokeURL = "https://id.twitch.tv/oauth2/revoke"
 
-	// Read existing env, tolerate missing .env by starting fresh
-	envMap, err := godotenv.Read(".env")
+// RevokeToken asks Twitch to revoke an access token. Revoking a token that
+// is already invalid is not an error.
+func RevokeToken(ctx context.Context, clientID, token string) error {
+	form := url.Values{"client_id": {clientID}, "token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oauthRevokeURL, strings.NewReader(form.Encode()))
 	if err != nil {
-		envMap = map[string]string{}
+		return fmt.Errorf("failed to create revoke request: %w", err)
 	}
-	envMap["TWITCH_APP_ACCESS_TOKEN"] = tokenResp.AccessToken
-	envMap["TWITCH_APP_ACCESS_TOKEN_EXPIRES_AT"] = expiresAt.Format(time.RFC3339)
-	if err := godotenv.Write(envMap, ".env"); err != nil {
-		return fmt.Errorf("failed to write .env file: %w", err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	// Twitch answers 400 "Invalid token" for tokens that are already dead.
+	if resp.StatusCode != http.StatusOK && !(resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "Invalid token")) {
+		return fmt.Errorf("failed to revoke token: status %d, body %s", resp.StatusCode, string(body))
 	}
-
-	// Export to current process environment as well
-	os.Setenv("TWITCH_APP_ACCESS_TOKEN", tokenResp.AccessToken)
-	os.Setenv("TWITCH_APP_ACCESS_TOKEN_EXPIRES_AT", expiresAt.Format(time.RFC3339))
-
 	return nil
 }
 
-func AuthorizeHandler(c *fiber.Ctx) error {
-	clientID := os.Getenv("TWITCH_CLIENT_ID")
-	redirectURI := os.Getenv("TWITCH_REDIRECT_URI")
-	scopes := "chat:read chat:edit user:read:email"
-	authURL := "https://id.twitch.tv/oauth2/authorize?response_type=code&client_id=" + url.QueryEscape(clientID) + "&redirect_uri=" + url.QueryEscape(redirectURI) + "&scope=" + url.QueryEscape(scopes)
-	return c.Redirect(authURL)
-}
-
-func AuthCallbackHandler(c *fiber.Ctx) error {
-	code := c.Query("code")
-	if code == "" {
-		return c.Status(400).SendString("Missing code parameter")
-	}
-	clientID := os.Getenv("TWITCH_CLIENT_ID")
-	clientSecret := os.Getenv("TWITCH_CLIENT_SECRET")
-	redirectURI := os.Getenv("TWITCH_REDIRECT_URI")
-	resp, err := http.PostForm("https://id.twitch.tv/oauth2/token", url.Values{
-		"client_id":     {clientID},
-		"client_secret": {clientSecret},
+// ExchangeCode trades an OAuth authorization code for a user token.
+func ExchangeCode(ctx context.Context, cfg config.Config, code string) (Token, error) {
+	resp, err := postToken(ctx, url.Values{
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
 		"code":          {code},
 		"grant_type":    {"authorization_code"},
-		"redirect_uri":  {redirectURI},
+		"redirect_uri":  {cfg.RedirectURI},
 	})
 	if err != nil {
-		return c.Status(500).SendString("Failed to exchange code: " + err.Error())
+		return Token{}, fmt.Errorf("twitch token exchange failed: %w", err)
 	}
-	defer resp.Body.Close()
-	body, _ := ioutil.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		return c.Status(500).SendString("Twitch token exchange failed: " + string(body))
-	}
-	var tokenResp struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
-	}
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return c.Status(500).SendString("Failed to parse token response: " + err.Error())
-	}
-	oauthToken.AccessToken = tokenResp.AccessToken
-	oauthToken.ExpiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
-	_ = UpdateEnvFile("TWITCH_USER_ACCESS_TOKEN", oauthToken.AccessToken)
-	_ = UpdateEnvFile("TWITCH_USER_ACCESS_TOKEN_EXPIRES_AT", oauthToken.ExpiresAt.Format(time.RFC3339))
-	os.Setenv("TWITCH_USER_ACCESS_TOKEN", oauthToken.AccessToken)
-	os.Setenv("TWITCH_USER_ACCESS_TOKEN_EXPIRES_AT", oauthToken.ExpiresAt.Format(time.RFC3339))
-	return c.SendString("<h2>Authorized!</h2><p>Access token set. Expires at: " + oauthToken.ExpiresAt.Format(time.RFC1123) + "</p>")
+	return resp.token(), nil
 }
 
-func AuthStatusHandler(c *fiber.Ctx) error {
-	if oauthToken.AccessToken == "" {
-		return c.JSON(fiber.Map{"authorized": false})
+// refreshUserToken renews a user token using its refresh token.
+func refreshUserToken(ctx context.Context, cfg config.Config, current Token) (Token, error) {
+	if current.RefreshToken == "" {
+		return Token{}, fmt.Errorf("no refresh token stored; re-authorize at /authorize")
 	}
-	return c.JSON(fiber.Map{
-		"authorized": true,
-		"expires_at": oauthToken.ExpiresAt,
+	resp, err := postToken(ctx, url.Values{
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {current.RefreshToken},
 	})
-}
-
-func CallbackAliasHandler(c *fiber.Ctx) error {
-	return c.Redirect("/auth/callback?" + c.Context().QueryArgs().String())
+	if err != nil {
+		return Token{}, err
+	}
+	return resp.token(), nil
 }
@@ -0,0 +1,207 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+
+	"go-twitch/config"
+)
+
+// ChatTransport selects how chat messages are sent.
+type ChatTransport string
+
+const (
+	// ChatTransportIRC sends over the account's IRC connection.
+	ChatTransportIRC ChatTransport = "irc"
+	// ChatTransportHelix sends with the Helix Send Chat Message API, falling
+	// back to IRC if the request fails.
+	ChatTransportHelix ChatTransport = "helix"
+)
+
+// ParseChatTransport parses s. Empty is returned as is and stands for the
+// default transport.
+func ParseChatTransport(s string) (ChatTransport, error) {
+	switch t := ChatTransport(s); t {
+	case "", ChatTransportIRC, ChatTransportHelix:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown chat transport %q", s)
+	}
+}
+
+// Drop reasons a ChatSendResult reports for messages that never reached
+// Twitch or were not confirmed. Messages Twitch rejects carry its own code
+// instead, e.g. msg_ratelimit, msg_banned or msg_duplicate.
+const (
+	ReasonQueueFull   = "queue_full"
+	ReasonNotJoined   = "not_joined"
+	ReasonTimeout     = "timeout"
+	ReasonUnconfirmed = "unconfirmed"
+	ReasonLoggedOut   = "logged_out"
+)
+
+// DropReason says why a chat message was not sent.
+type DropReason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ChatSendResult is the outcome of sending a chat message, whichever
+// transport sent it.
+type ChatSendResult struct {
+	Transport ChatTransport `json:"transport"`
+	Channel   string        `json:"channel"`
+	Account   string        `json:"account"`
+	// IsSent is set once Twitch accepted the message.
+	IsSent bool `json:"is_sent"`
+	// MessageID is the ID Twitch gave the message, if it was sent.
+	MessageID string `json:"message_id,omitempty"`
+	// DropReason is set when the message was not sent. ReasonUnconfirmed
+	// means it was written but Twitch neither confirmed nor rejected it.
+	DropReason *DropReason `json:"drop_reason,omitempty"`
+	// Queue describes the account's IRC send queue after the message.
+	Queue *ChatQueueStats `json:"queue,omitempty"`
+}
+
+// SendChatMessageRequest is the body of a Helix Send Chat Message request.
+type SendChatMessageRequest struct {
+	BroadcasterID        string `json:"broadcaster_id"`
+	SenderID             string `json:"sender_id"`
+	Message              string `json:"message"`
+	ReplyParentMessageID string `json:"reply_parent_message_id,omitempty"`
+}
+
+// SentChatMessage is a single entry of the Helix Send Chat Message response.
+type SentChatMessage struct {
+	MessageID  string      `json:"message_id"`
+	IsSent     bool        `json:"is_sent"`
+	DropReason *DropReason `json:"drop_reason"`
+}
+
+// SendChatMessageResponse represents the Helix Send Chat Message response.
+type SendChatMessageResponse struct {
+	Data []SentChatMessage `json:"data"`
+}
+
+// SendChatMessage sends a chat message with the Helix API. With a user token
+// the sender needs the user:write:chat scope; with the app token the sender
+// must have granted user:bot and the broadcaster channel:bot, and the message
+// shows the chat bot badge.
+func (c *Client) SendChatMessage(ctx context.Context, req SendChatMessageRequest, appToken bool) (*SentChatMessage, error) {
+	mode := authUser
+	if appToken {
+		mode = authApp
+	}
+	res, err := post[SendChatMessageResponse](ctx, c, mode, "/chat/messages", req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send chat message: %w", err)
+	}
+	if len(res.Data) == 0 {
+		return nil, fmt.Errorf("failed to send chat message: empty response")
+	}
+	return &res.Data[0], nil
+}
+
+// ChatSender sends chat messages over the configured transport. Messages
+// that Helix fails to send, as opposed to ones it drops, go out over IRC
+// instead.
+type ChatSender struct {
+	Pool  *IRCPool
+	Helix *Client
+	// Transport is used when Send is given none.
+	Transport ChatTransport
+	// HelixAppToken sends Helix messages with the app token.
+	HelixAppToken bool
+
+	mu sync.Mutex
+	// broadcasters caches the user IDs of channels.
+	broadcasters map[string]string
+}
+
+// NewChatSender returns a ChatSender configured from cfg.
+func NewChatSender(cfg config.Config, pool *IRCPool, helix *Client) *ChatSender {
+	transport, err := ParseChatTransport(cfg.ChatTransport)
+	if err != nil || transport == "" {
+		if err != nil {
+			log.Printf("[IRC] %v, using %s", err, ChatTransportIRC)
+		}
+		transport = ChatTransportIRC
+	}
+	return &ChatSender{
+		Pool:          pool,
+		Helix:         helix,
+		Transport:     transport,
+		HelixAppToken: cfg.ChatHelixAuth == "app",
+		broadcasters:  make(map[string]string),
+	}
+}
+
+// Send sends message to channel over transport, or the default transport if
+// it is empty, and waits for the outcome. Unless replyTo is empty, the
+// message is a reply to the message with that ID.
+func (s *ChatSender) Send(ctx context.Context, channel, message, replyTo string, transport ChatTransport) (ChatSendResult, error) {
+	if transport == "" {
+		transport = s.Transport
+	}
+	if transport == ChatTransportHelix {
+		r, err := s.sendHelix(ctx, normalizeChannel(channel), message, replyTo)
+		if err == nil {
+			return r, nil
+		}
+		log.Printf("[HELIX] %v; sending over IRC instead", err)
+	}
+	return s.Pool.Send(ctx, channel, message, replyTo)
+}
+
+func (s *ChatSender) sendHelix(ctx context.Context, channel, message, replyTo string) (ChatSendResult, error) {
+	account, err := s.Pool.Account(channel)
+	if err != nil {
+		return ChatSendResult{}, err
+	}
+	sender := account.Current()
+	broadcasterID, err := s.broadcasterID(ctx, channel)
+	if err != nil {
+		return ChatSendResult{}, err
+	}
+	sent, err := s.Helix.SendChatMessage(WithUserToken(ctx, account), SendChatMessageRequest{
+		BroadcasterID:        broadcasterID,
+		SenderID:             sender.UserID,
+		Message:              message,
+		ReplyParentMessageID: replyTo,
+	}, s.HelixAppToken)
+	if err != nil {
+		return ChatSendResult{}, err
+	}
+	return ChatSendResult{
+		Transport:  ChatTransportHelix,
+		Channel:    channel,
+		Account:    sender.Login,
+		IsSent:     sent.IsSent,
+		MessageID:  sent.MessageID,
+		DropReason: sent.DropReason,
+	}, nil
+}
+
+// broadcasterID returns the user ID of channel, looking it up once.
+func (s *ChatSender) broadcasterID(ctx context.Context, channel string) (string, error) {
+	s.mu.Lock()
+	id, ok := s.broadcasters[channel]
+	s.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	res, err := get[UserResponse](ctx, s.Helix, authUserOrApp, "/users", url.Values{"login": {channel}})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up channel %s: %w", channel, err)
+	}
+	if len(res.Data) == 0 {
+		return "", fmt.Errorf("channel %s not found", channel)
+	}
+	s.mu.Lock()
+	s.broadcasters[channel] = res.Data[0].ID
+	s.mu.Unlock()
+	return res.Data[0].ID, nil
+}
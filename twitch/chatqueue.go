@@ -0,0 +1,305 @@
+package twitch
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// chatWindow is the period Twitch counts an account's chat messages over.
+	chatWindow = 30 * time.Second
+	// chatLimit is the number of messages an account may send per chatWindow
+	// to channels where it is neither moderator, VIP nor broadcaster.
+	chatLimit = 20
+	// chatPrivilegedLimit applies to channels where the account is moderator,
+	// VIP or broadcaster.
+	chatPrivilegedLimit = 100
+	// chatQueueSize caps the messages waiting per account; further messages
+	// are dropped.
+	chatQueueSize = 100
+	// chatDuplicateWindow is how long Twitch rejects a repeat of the previous
+	// message in a channel.
+	chatDuplicateWindow = 30 * time.Second
+	// chatDuplicateSuffix makes a repeated message differ from the previous
+	// one without changing how it looks in chat.
+	chatDuplicateSuffix = " \U000E0000"
+	// chatConfirmTimeout is how long a written message waits for Twitch to
+	// confirm or reject it.
+	chatConfirmTimeout = 10 * time.Second
+	// chatLateConfirmWindow is how long after timing out a message may still
+	// receive a late confirmation, which is then dropped rather than taken
+	// for the next message's. Kept short, as a confirmation that never comes
+	// shifts later ones onto the wrong message until the window passes.
+	chatLateConfirmWindow = 5 * time.Second
+)
+
+// ErrChatQueueFull is returned when an account already has chatQueueSize
+// messages waiting to be sent.
+var ErrChatQueueFull = errors.New("outgoing chat queue is full")
+
+// ChatQueueStats describes the outgoing queue of one account.
+type ChatQueueStats struct {
+	Account string `json:"account"`
+	// Depth is the number of messages waiting to be sent.
+	Depth   int    `json:"queue_depth"`
+	Sent    uint64 `json:"sent"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// chatQueue sends the chat messages of one account in order, holding each
+// back until its channel is joined and Twitch's rate limit and the channel's
+// slow mode allow it.
+type chatQueue struct {
+	pool    *IRCPool
+	account *TokenManager
+
+	mu      sync.Mutex
+	pending []*outgoing
+	wake    chan struct{}
+	// stop is closed when the account is removed; closed is set with it.
+	stop   chan struct{}
+	closed bool
+	// sent holds the send times within the last chatWindow.
+	sent []time.Time
+	// last is the last message sent per channel, as it went out.
+	last    map[string]lastMessage
+	total   uint64
+	dropped uint64
+}
+
+// outgoing is a queued message. Its result is delivered on done once Twitch
+// confirmed or rejected it.
+type outgoing struct {
+	channel string
+	account string
+	text    string
+	// replyTo is the ID of the message this one replies to, if any.
+	replyTo string
+	done    chan ChatSendResult
+	once    sync.Once
+	// expired is when the message timed out waiting for confirmation, zero
+	// before. It is guarded by the pool's mu.
+	expired time.Time
+}
+
+func (m *outgoing) finish(r ChatSendResult) {
+	m.once.Do(func() { m.done <- r })
+}
+
+// result returns the result of m being dropped for reason.
+func (m *outgoing) result(reason, message string) ChatSendResult {
+	return ChatSendResult{
+		Transport:  ChatTransportIRC,
+		Channel:    m.channel,
+		Account:    m.account,
+		DropReason: &DropReason{Code: reason, Message: message},
+	}
+}
+
+type lastMessage struct {
+	text string
+	at   time.Time
+}
+
+func newChatQueue(pool *IRCPool, account *TokenManager) *chatQueue {
+	q := &chatQueue{
+		pool:    pool,
+		account: account,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		last:    make(map[string]lastMessage),
+	}
+	go q.run()
+	return q
+}
+
+// push queues text for channel, replying to the message replyTo unless it
+// is empty, or drops it if the queue is full.
+func (q *chatQueue) push(channel, text, replyTo string) (*outgoing, ChatQueueStats, error) {
+	q.mu.Lock()
+	if q.closed {
+		m := &outgoing{channel: channel, account: q.account.Current().Login, text: text, replyTo: replyTo, done: make(chan ChatSendResult, 1)}
+		q.dropped++
+		stats := q.statsLocked()
+		q.mu.Unlock()
+		m.finish(m.result(ReasonLoggedOut, "the account was logged out"))
+		return m, stats, nil
+	}
+	if len(q.pending) >= chatQueueSize {
+		q.dropped++
+		stats := q.statsLocked()
+		q.mu.Unlock()
+		return nil, stats, ErrChatQueueFull
+	}
+	m := &outgoing{channel: channel, account: q.account.Current().Login, text: text, replyTo: replyTo, done: make(chan ChatSendResult, 1)}
+	q.pending = append(q.pending, m)
+	stats := q.statsLocked()
+	q.mu.Unlock()
+	q.poke()
+	return m, stats, nil
+}
+
+// cancel removes m from the queue. It reports false if m was already sent.
+func (q *chatQueue) cancel(m *outgoing) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, p := range q.pending {
+		if p == m {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.dropped++
+			return true
+		}
+	}
+	return false
+}
+
+// close stops the queue of a removed account and drops the messages still
+// waiting in it.
+func (q *chatQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	pending := q.pending
+	q.pending = nil
+	q.dropped += uint64(len(pending))
+	q.mu.Unlock()
+	close(q.stop)
+	for _, m := range pending {
+		m.finish(m.result(ReasonLoggedOut, "the account was logged out"))
+	}
+}
+
+// poke makes the queue look for sendable messages again.
+func (q *chatQueue) poke() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *chatQueue) stats() ChatQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.statsLocked()
+}
+
+func (q *chatQueue) statsLocked() ChatQueueStats {
+	return ChatQueueStats{
+		Account: q.account.Current().Login,
+		Depth:   len(q.pending),
+		Sent:    q.total,
+		Dropped: q.dropped,
+	}
+}
+
+// run sends queued messages as soon as they are allowed, until the queue is
+// closed. A message held back by one channel does not delay messages to
+// other channels.
+func (q *chatQueue) run() {
+	timer := time.NewTimer(0)
+	<-timer.C
+	for {
+		wait := q.sendReady()
+		if wait < 0 {
+			select {
+			case <-q.wake:
+			case <-q.stop:
+				return
+			}
+			continue
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-q.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-q.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// sendReady sends every queued message that may go out now. It returns how
+// long until the next one may, or -1 if nothing can go out before the queue
+// is poked.
+func (q *chatQueue) sendReady() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	next := time.Duration(-1)
+	for i := 0; i < len(q.pending); {
+		m := q.pending[i]
+		ch, ok := q.pool.channelState(m.channel)
+		if !ok {
+			log.Printf("[IRC] dropping message to #%s: channel is no longer joined", m.channel)
+			q.dropped++
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			m.finish(m.result(ReasonNotJoined, "channel is no longer joined"))
+			continue
+		}
+		if !ch.joined {
+			// Joining pokes the queue.
+			i++
+			continue
+		}
+		now := time.Now()
+		wait := q.delay(m.channel, ch, now)
+		if wait > 0 {
+			if next < 0 || wait < next {
+				next = wait
+			}
+			i++
+			continue
+		}
+		text := m.text
+		if last, ok := q.last[m.channel]; ok && !ch.privileged && now.Sub(last.at) < chatDuplicateWindow && last.text == text {
+			text += chatDuplicateSuffix
+		}
+		if !q.pool.write(m, text) {
+			// The channel moved to another connection; retry there.
+			i++
+			continue
+		}
+		q.sent = append(q.sent, now)
+		q.last[m.channel] = lastMessage{text: text, at: now}
+		q.total++
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+	}
+	return next
+}
+
+// delay returns how long a message to channel must still wait. Callers hold
+// mu.
+func (q *chatQueue) delay(channel string, ch chatChannelState, now time.Time) time.Duration {
+	for len(q.sent) > 0 && now.Sub(q.sent[0]) >= chatWindow {
+		q.sent = q.sent[1:]
+	}
+	var wait time.Duration
+	limit := chatLimit
+	if ch.privileged {
+		limit = chatPrivilegedLimit
+	}
+	if len(q.sent) >= limit {
+		wait = q.sent[len(q.sent)-limit].Add(chatWindow).Sub(now)
+	}
+	if ch.slow > 0 && !ch.privileged {
+		if last, ok := q.last[channel]; ok {
+			wait = max(wait, last.at.Add(ch.slow).Sub(now))
+		}
+	}
+	return wait
+}
+
+// chatChannelState is what the queue needs to know about a joined channel.
+type chatChannelState struct {
+	joined     bool
+	privileged bool
+	slow       time.Duration
+}
@@ -0,0 +1,254 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go-twitch/config"
+)
+
+// DefaultBaseURL is the production Helix API root.
+const DefaultBaseURL = "https://api.twitch.tv/helix"
+
+// authMode selects which token the auth middleware attaches to a request.
+type authMode int
+
+const (
+	authApp authMode = iota
+	authUser
+	// authUserOrApp prefers the user token and falls back to the app token.
+	authUserOrApp
+)
+
+type authModeKey struct{}
+
+type userTokenKey struct{}
+
+// WithUserToken returns a context under which user authenticated requests
+// use src instead of Client.UserToken, e.g. to act as a channel's
+// broadcaster account.
+func WithUserToken(ctx context.Context, src TokenSource) context.Context {
+	return context.WithValue(ctx, userTokenKey{}, src)
+}
+
+// Client is a Helix API client. Construct it with NewClient; the zero value
+// is not usable. A Client is safe for concurrent use.
+type Client struct {
+	// BaseURL is the Helix API root, without a trailing slash. Point it at a
+	// local mock server in tests.
+	BaseURL  string
+	ClientID string
+
+	// UserToken and AppToken supply the bearer tokens for user and app
+	// authenticated requests.
+	UserToken TokenSource
+	AppToken  TokenSource
+
+	// Retry controls retries of rate limited and unavailable responses.
+	Retry RetryPolicy
+
+	http    *http.Client
+	limiter *RateLimiter
+}
+
+// NewClient builds a Client from cfg. httpClient may be nil, in which case a
+// default client is used. Its Transport is wrapped with the auth middleware;
+// the passed client itself is not modified.
+func NewClient(cfg config.Config, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	baseURL := cfg.HelixBaseURL
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	c := &Client{
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+		ClientID:  cfg.ClientID,
+		UserToken: StaticToken(cfg.UserToken),
+		AppToken:  StaticToken(cfg.AppToken),
+		Retry: RetryPolicy{
+			MaxRetries: cfg.HelixMaxRetries,
+			BaseDelay:  cfg.HelixRetryBaseDelay,
+			MaxDelay:   cfg.HelixRetryMaxDelay,
+		},
+		limiter: NewRateLimiter(),
+	}
+	wrapped := *httpClient
+	wrapped.Transport = &authTransport{client: c, base: httpClient.Transport}
+	c.http = &wrapped
+	return c
+}
+
+// authTransport is the shared auth-header middleware for Helix requests. It
+// sets Client-Id and the bearer token chosen by the request's authMode, then
+// hands the request to the rate limited, retrying sender.
+type authTransport struct {
+	client *Client
+	base   http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.client.ClientID == "" {
+		return nil, fmt.Errorf("TWITCH_CLIENT_ID environment variable not set")
+	}
+	mode, _ := req.Context().Value(authModeKey{}).(authMode)
+	source, token, err := t.client.token(req.Context(), mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Client-Id", t.client.ClientID)
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := t.client.send(base, req, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Helix also answers 401 for missing scopes, which a new token does not
+	// fix; only renew a token it says is invalid or expired.
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	inv, ok := source.(invalidator)
+	if !ok || !tokenRejected(body) || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	// The token was rejected: renew it once and replay the request.
+	token, err = inv.Invalidate(req.Context(), token)
+	if err != nil {
+		log.Printf("[HELIX] %v", err)
+		return resp, nil
+	}
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.client.send(base, req, token)
+}
+
+// tokenRejected reports whether the body of a 401 response says the token
+// itself is invalid or expired, e.g. "Invalid OAuth token", rather than
+// lacking a scope.
+func tokenRejected(body []byte) bool {
+	var e struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return false
+	}
+	msg := strings.ToLower(e.Message)
+	return strings.Contains(msg, "token") && (strings.Contains(msg, "invalid") || strings.Contains(msg, "expired"))
+}
+
+// invalidator is implemented by token sources that can renew a token Twitch
+// has rejected, such as TokenManager.
+type invalidator interface {
+	Invalidate(ctx context.Context, rejected string) (string, error)
+}
+
+func (c *Client) token(ctx context.Context, mode authMode) (TokenSource, string, error) {
+	user := c.UserToken
+	if src, ok := ctx.Value(userTokenKey{}).(TokenSource); ok {
+		user = src
+	}
+	switch mode {
+	case authUser:
+		token, err := user.Token()
+		return user, token, err
+	case authUserOrApp:
+		if token, err := user.Token(); err == nil {
+			return user, token, nil
+		}
+		token, err := c.AppToken.Token()
+		return c.AppToken, token, err
+	default:
+		token, err := c.AppToken.Token()
+		return c.AppToken, token, err
+	}
+}
+
+// APIError is returned when Helix answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("status %d, body %s", e.StatusCode, e.Body)
+}
+
+// get issues a GET against path (relative to BaseURL) and decodes the JSON
+// response into T.
+func get[T any](ctx context.Context, c *Client, mode authMode, path string, query url.Values) (*T, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, authModeKey{}, mode), http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	return do[T](c, req)
+}
+
+// post issues a POST of body, encoded as JSON, against path (relative to
+// BaseURL) and decodes the JSON response into T.
+func post[T any](ctx context.Context, c *Client, mode authMode, path string, body any) (*T, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, authModeKey{}, mode), http.MethodPost, c.BaseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do[T](c, req)
+}
+
+// do sends req through the authenticated client and decodes the JSON
+// response into T.
+func do[T any](c *Client, req *http.Request) (*T, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	var out T
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &out); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+	}
+	return &out, nil
+}
@@ -0,0 +1,110 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-twitch/config"
+)
+
+const oauthDeviceURL = "https://id.twitch.tv/oauth2/device"
+
+var (
+	// ErrAuthorizationPending means the user has not approved the device yet.
+	ErrAuthorizationPending = errors.New("authorization pending")
+	// ErrDeviceCodeExpired means the user code expired before it was used.
+	ErrDeviceCodeExpired = errors.New("device code expired")
+)
+
+// DeviceAuthorization is a pending Device Code Grant. Show UserCode and
+// VerificationURI to the user, then poll with PollDeviceToken.
+type DeviceAuthorization struct {
+	DeviceCode      string
+	UserCode        string
+	VerificationURI string
+	Scopes          []string
+	ExpiresAt       time.Time
+	// Interval is the minimum time between polls.
+	Interval time.Duration
+}
+
+// StartDeviceAuth begins a Device Code Grant for scopes, for deployments
+// where no browser can reach TWITCH_REDIRECT_URI.
+func StartDeviceAuth(ctx context.Context, cfg config.Config, scopes []string) (*DeviceAuthorization, error) {
+	var reply struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURI string `json:"verification_uri"`
+		ExpiresIn       int    `json:"expires_in"`
+		Interval        int    `json:"interval"`
+	}
+	err := postOAuth(ctx, oauthDeviceURL, url.Values{
+		"client_id": {cfg.ClientID},
+		"scopes":    {strings.Join(scopes, " ")},
+	}, &reply)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %w", err)
+	}
+	interval := time.Duration(reply.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return &DeviceAuthorization{
+		DeviceCode:      reply.DeviceCode,
+		UserCode:        reply.UserCode,
+		VerificationURI: reply.VerificationURI,
+		Scopes:          scopes,
+		ExpiresAt:       time.Now().Add(time.Duration(reply.ExpiresIn) * time.Second),
+		Interval:        interval,
+	}, nil
+}
+
+// PollDeviceToken asks Twitch once whether d has been approved. It returns
+// ErrAuthorizationPending while waiting for the user and
+// ErrDeviceCodeExpired once the code can no longer be used. A slow_down reply
+// lengthens d.Interval.
+func PollDeviceToken(ctx context.Context, cfg config.Config, d *DeviceAuthorization) (Token, error) {
+	if time.Now().After(d.ExpiresAt) {
+		return Token{}, ErrDeviceCodeExpired
+	}
+	resp, err := postToken(ctx, url.Values{
+		"client_id":   {cfg.ClientID},
+		"scopes":      {strings.Join(d.Scopes, " ")},
+		"device_code": {d.DeviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	})
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		switch oauthErr.Message {
+		case "authorization_pending":
+			return Token{}, ErrAuthorizationPending
+		case "slow_down":
+			d.Interval += 5 * time.Second
+			return Token{}, ErrAuthorizationPending
+		case "expired_token", "invalid device code":
+			return Token{}, ErrDeviceCodeExpired
+		}
+	}
+	if err != nil {
+		return Token{}, fmt.Errorf("device token request failed: %w", err)
+	}
+	return resp.token(), nil
+}
+
+// WaitDeviceToken polls until d is approved, expires or ctx is cancelled.
+func WaitDeviceToken(ctx context.Context, cfg config.Config, d *DeviceAuthorization) (Token, error) {
+	for {
+		if err := sleep(ctx, d.Interval); err != nil {
+			return Token{}, err
+		}
+		token, err := PollDeviceToken(ctx, cfg, d)
+		if errors.Is(err, ErrAuthorizationPending) {
+			continue
+		}
+		return token, err
+	}
+}
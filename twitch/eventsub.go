@@ -0,0 +1,305 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-twitch/events"
+)
+
+// EventSub message types, sent as metadata by the WebSocket transport and
+// in the Twitch-Eventsub-Message-Type header by the webhook transport.
+const (
+	EventSubNotification = "notification"
+	EventSubRevocation   = "revocation"
+	EventSubWelcome      = "session_welcome"
+	EventSubKeepalive    = "session_keepalive"
+	EventSubReconnect    = "session_reconnect"
+	EventSubVerification = "webhook_callback_verification"
+)
+
+const (
+	// eventSubDedupWindow is how long message IDs are remembered. Twitch
+	// redelivers within it, and webhook messages older than it are stale.
+	eventSubDedupWindow = 10 * time.Minute
+	// eventSubCreateTimeout bounds creating a session's subscriptions.
+	eventSubCreateTimeout = 30 * time.Second
+)
+
+// DefaultEventSubTypes are the subscription types created when none are
+// configured. Their scopes are those of the eventsub feature.
+var DefaultEventSubTypes = []string{
+	"channel.follow",
+	"channel.subscribe",
+	"channel.subscription.gift",
+	"channel.subscription.message",
+	"channel.cheer",
+	"channel.raid",
+	"channel.channel_points_custom_reward_redemption.add",
+	"stream.online",
+	"stream.offline",
+}
+
+// eventSubVersions are the versions of the subscription types Subscribe
+// knows how to create; other types are created as version 1.
+var eventSubVersions = map[string]string{
+	"channel.follow": "2",
+	"channel.update": "2",
+}
+
+// EventSubMetadata describes an EventSub message.
+type EventSubMetadata struct {
+	MessageID           string    `json:"message_id"`
+	MessageType         string    `json:"message_type"`
+	MessageTimestamp    time.Time `json:"message_timestamp"`
+	SubscriptionType    string    `json:"subscription_type,omitempty"`
+	SubscriptionVersion string    `json:"subscription_version,omitempty"`
+}
+
+// EventSubSession is a WebSocket transport session.
+type EventSubSession struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// KeepaliveTimeoutSeconds is the longest Twitch stays silent on a
+	// healthy session.
+	KeepaliveTimeoutSeconds int `json:"keepalive_timeout_seconds"`
+	// ReconnectURL is set on reconnect messages.
+	ReconnectURL string    `json:"reconnect_url"`
+	ConnectedAt  time.Time `json:"connected_at"`
+}
+
+// EventSubTransport is where a subscription's notifications are delivered.
+type EventSubTransport struct {
+	// Method is websocket or webhook.
+	Method    string `json:"method"`
+	Callback  string `json:"callback,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// EventSubSubscription is a subscription as Twitch reports it.
+type EventSubSubscription struct {
+	ID        string            `json:"id"`
+	Status    string            `json:"status"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport EventSubTransport `json:"transport"`
+	CreatedAt time.Time         `json:"created_at"`
+	Cost      int               `json:"cost"`
+}
+
+// EventSubPayload is the body of webhook requests and the payload of
+// WebSocket messages. Which fields are set depends on the message type.
+type EventSubPayload struct {
+	Session      *EventSubSession      `json:"session,omitempty"`
+	Subscription *EventSubSubscription `json:"subscription,omitempty"`
+	// Event is the subscription type specific payload of notifications.
+	Event json.RawMessage `json:"event,omitempty"`
+	// Challenge is set on webhook verification requests.
+	Challenge string `json:"challenge,omitempty"`
+}
+
+// eventSubMessage is a message of the WebSocket transport.
+type eventSubMessage struct {
+	Metadata EventSubMetadata `json:"metadata"`
+	Payload  EventSubPayload  `json:"payload"`
+}
+
+// notification returns the bus event of a notification. Its channel is the
+// broadcaster the event belongs to.
+func (p EventSubPayload) notification(m EventSubMetadata) events.EventSubNotification {
+	var broadcaster struct {
+		Login   string `json:"broadcaster_user_login"`
+		ToLogin string `json:"to_broadcaster_user_login"`
+	}
+	json.Unmarshal(p.Event, &broadcaster)
+	channel := broadcaster.Login
+	if channel == "" {
+		channel = broadcaster.ToLogin
+	}
+	n := events.EventSubNotification{
+		Header:    events.Header{Channel: channel, Time: eventTime(m.MessageTimestamp)},
+		MessageID: m.MessageID,
+		Type:      m.SubscriptionType,
+		Version:   m.SubscriptionVersion,
+		Event:     p.Event,
+	}
+	if p.Subscription != nil {
+		n.Type, n.Version = p.Subscription.Type, p.Subscription.Version
+	}
+	return n
+}
+
+// CreateEventSubSubscriptionRequest is the body of a Helix Create EventSub
+// Subscription request.
+type CreateEventSubSubscriptionRequest struct {
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport EventSubTransport `json:"transport"`
+}
+
+// EventSubSubscriptionsResponse represents the Helix EventSub subscriptions
+// response.
+type EventSubSubscriptionsResponse struct {
+	Data         []EventSubSubscription `json:"data"`
+	Total        int                    `json:"total"`
+	TotalCost    int                    `json:"total_cost"`
+	MaxTotalCost int                    `json:"max_total_cost"`
+}
+
+// CreateEventSubSubscription creates an EventSub subscription. WebSocket
+// subscriptions need a user token, webhook subscriptions the app token.
+func (c *Client) CreateEventSubSubscription(ctx context.Context, req CreateEventSubSubscriptionRequest) (*EventSubSubscription, error) {
+	mode := authApp
+	if req.Transport.Method == "websocket" {
+		mode = authUser
+	}
+	res, err := post[EventSubSubscriptionsResponse](ctx, c, mode, "/eventsub/subscriptions", req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s subscription: %w", req.Type, err)
+	}
+	if len(res.Data) == 0 {
+		return nil, fmt.Errorf("failed to create %s subscription: empty response", req.Type)
+	}
+	return &res.Data[0], nil
+}
+
+// subscribeChannels subscribes every channel with subscribe, retrying the
+// ones that fail with backoff, e.g. until an account acting for them is
+// authorized. Once all succeeded it waits for retry, fired when
+// subscriptions fail to be created later on, and subscribes them all again;
+// with a nil retry it returns instead. It returns when ctx is cancelled.
+func subscribeChannels(ctx context.Context, channels []string, subscribe func(channel string) error, retry <-chan struct{}) {
+	backoff := eventSubRetryMin
+	pending := channels
+	for {
+		// This round covers failures reported so far.
+		select {
+		case <-retry:
+		default:
+		}
+		var failed []string
+		for _, channel := range pending {
+			if err := subscribe(channel); err != nil {
+				log.Printf("[EVENTSUB] %v; retrying in %s", err, backoff)
+				failed = append(failed, channel)
+			}
+		}
+		pending = failed
+		if len(pending) == 0 {
+			if retry == nil {
+				return
+			}
+			waiting := time.Now()
+			select {
+			case <-ctx.Done():
+				return
+			case <-retry:
+			}
+			if time.Since(waiting) > eventSubRetryMax {
+				backoff = eventSubRetryMin
+			}
+			log.Printf("[EVENTSUB] subscriptions failed; retrying in %s", backoff)
+			pending = channels
+		}
+		if sleep(ctx, backoff) != nil {
+			return
+		}
+		backoff = min(backoff*2, eventSubRetryMax)
+	}
+}
+
+// eventSubTarget returns the account acting for channel in EventSub
+// subscriptions, the broadcaster else a moderator, and the channel's user ID.
+func eventSubTarget(ctx context.Context, helix *Client, accounts *Accounts, channel string) (*TokenManager, string, error) {
+	account, err := accounts.ForChannel(channel, RoleBroadcaster)
+	if err != nil {
+		if account, err = accounts.ForChannel(channel, RoleModerator); err != nil {
+			return nil, "", err
+		}
+	}
+	users, err := helix.GetUserInfo(WithUserToken(ctx, account), channel)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to look up channel %s: %w", channel, err)
+	}
+	if len(users.Data) == 0 {
+		return nil, "", fmt.Errorf("channel %s not found", channel)
+	}
+	return account, users.Data[0].ID, nil
+}
+
+// eventSubRequest returns the request subscribing to typ in the channel of
+// broadcasterID, acting as the user userID.
+func eventSubRequest(typ, broadcasterID, userID string) CreateEventSubSubscriptionRequest {
+	version := eventSubVersions[typ]
+	if version == "" {
+		version = "1"
+	}
+	condition := map[string]string{"broadcaster_user_id": broadcasterID}
+	switch typ {
+	case "channel.follow":
+		condition["moderator_user_id"] = userID
+	case "channel.raid":
+		condition = map[string]string{"to_broadcaster_user_id": broadcasterID}
+	}
+	return CreateEventSubSubscriptionRequest{Type: typ, Version: version, Condition: condition}
+}
+
+// sameSubscription reports whether a and b subscribe to the same events.
+// Twitch may report unused condition fields as empty.
+func sameSubscription(a, b CreateEventSubSubscriptionRequest) bool {
+	if a.Type != b.Type || a.Version != b.Version {
+		return false
+	}
+	for k, v := range a.Condition {
+		if b.Condition[k] != v {
+			return false
+		}
+	}
+	for k, v := range b.Condition {
+		if a.Condition[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// messageIDs remembers the IDs of recent EventSub messages, which Twitch
+// may deliver more than once.
+type messageIDs struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func newMessageIDs(window time.Duration) *messageIDs {
+	return &messageIDs{window: window, seen: make(map[string]time.Time)}
+}
+
+// add records id and reports whether it is new within the window.
+func (m *messageIDs) add(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if at, ok := m.seen[id]; ok && now.Sub(at) < m.window {
+		return false
+	}
+	m.seen[id] = now
+	if now.Sub(m.pruned) >= m.window {
+		for k, at := range m.seen {
+			if now.Sub(at) >= m.window {
+				delete(m.seen, k)
+			}
+		}
+		m.pruned = now
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package twitch
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-twitch/config"
+	"go-twitch/events"
+)
+
+// Headers of EventSub webhook requests.
+const (
+	EventSubHeaderMessageID           = "Twitch-Eventsub-Message-Id"
+	EventSubHeaderMessageType         = "Twitch-Eventsub-Message-Type"
+	EventSubHeaderTimestamp           = "Twitch-Eventsub-Message-Timestamp"
+	EventSubHeaderSignature           = "Twitch-Eventsub-Message-Signature"
+	EventSubHeaderSubscriptionType    = "Twitch-Eventsub-Subscription-Type"
+	EventSubHeaderSubscriptionVersion = "Twitch-Eventsub-Subscription-Version"
+)
+
+var (
+	// ErrEventSubSignature is returned for webhook requests not signed with
+	// the secret.
+	ErrEventSubSignature = errors.New("invalid EventSub message signature")
+	// ErrEventSubStale is returned for webhook requests whose timestamp is
+	// too far from now to be fresh.
+	ErrEventSubStale = errors.New("stale EventSub message timestamp")
+	// ErrEventSubDuplicate is returned for messages already handled. Twitch
+	// resends messages it got no response to in time, so they are to be
+	// acknowledged but not handled again.
+	ErrEventSubDuplicate = errors.New("duplicate EventSub message")
+)
+
+// EventSubWebhook receives EventSub messages sent to the webhook callback.
+// It verifies their signature and age, answers verification challenges,
+// resubscribes revoked subscriptions and publishes notifications on the bus, in the same
+// shape as the WebSocket transport. It is safe for concurrent use.
+type EventSubWebhook struct {
+	// Secret signs the messages; Twitch requires 10 to 100 characters.
+	Secret string
+	// Callback is the public URL of the callback route. When set, Run
+	// subscribes the configured channels with it.
+	Callback string
+
+	helix    *Client
+	accounts *Accounts
+	bus      *events.Bus
+	seen     *messageIDs
+	channels []string
+	types    []string
+	// retry is poked when Twitch revokes a subscription that can be
+	// created again, for Run to subscribe the configured channels again.
+	retry chan struct{}
+}
+
+// NewEventSubWebhook returns an EventSubWebhook publishing on bus,
+// configured from cfg.
+func NewEventSubWebhook(cfg config.Config, helix *Client, accounts *Accounts, bus *events.Bus) *EventSubWebhook {
+	types := cfg.EventSubTypes
+	if len(types) == 0 {
+		types = DefaultEventSubTypes
+	}
+	return &EventSubWebhook{
+		Secret:   cfg.EventSubWebhookSecret,
+		Callback: cfg.EventSubWebhookCallback,
+		helix:    helix,
+		accounts: accounts,
+		bus:      bus,
+		seen:     newMessageIDs(eventSubDedupWindow),
+		channels: cfg.EventSubChannels,
+		types:    types,
+		retry:    make(chan struct{}, 1),
+	}
+}
+
+// Run creates the webhook subscriptions of the configured channels. Twitch
+// keeps them across restarts; ones that already exist are left as they
+// are. Channels that fail, e.g. because no account acting for them is
+// authorized yet, are retried with backoff, and all channels are
+// subscribed again when Twitch revokes a subscription that can be created
+// again, until ctx is cancelled.
+func (w *EventSubWebhook) Run(ctx context.Context) {
+	subscribeChannels(ctx, w.channels, func(channel string) error {
+		return w.Subscribe(ctx, channel, w.types...)
+	}, w.retry)
+}
+
+// Subscribe subscribes Callback to the given types of events in channel.
+// Webhook subscriptions are created with the app token; the account acting
+// for the channel must have authorized the scopes they need.
+func (w *EventSubWebhook) Subscribe(ctx context.Context, channel string, types ...string) error {
+	if w.Callback == "" || w.Secret == "" {
+		return errors.New("EVENTSUB_WEBHOOK_CALLBACK and EVENTSUB_WEBHOOK_SECRET must be set for webhook subscriptions")
+	}
+	account, broadcasterID, err := eventSubTarget(ctx, w.helix, w.accounts, normalizeChannel(channel))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, typ := range types {
+		req := eventSubRequest(strings.TrimSpace(typ), broadcasterID, account.Current().UserID)
+		req.Transport = EventSubTransport{Method: "webhook", Callback: w.Callback, Secret: w.Secret}
+		sub, err := w.helix.CreateEventSubSubscription(ctx, req)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Printf("[EVENTSUB] subscribed to %s in %s via webhook (%s)", sub.Type, channel, sub.ID)
+	}
+	return errors.Join(errs...)
+}
+
+// eventSubResubscribable reports whether a subscription revoked with
+// status can be created again. Ones revoked because the user withdrew the
+// authorization, was removed or the version is gone would only fail again.
+func eventSubResubscribable(status string) bool {
+	switch status {
+	case "authorization_revoked", "user_removed", "version_removed":
+		return false
+	}
+	return true
+}
+
+// SignEventSub returns the Twitch-Eventsub-Message-Signature of a webhook
+// message, e.g. to sign fixtures for the callback.
+func SignEventSub(secret, messageID, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that a webhook request was signed with the secret and is
+// fresh, and returns its metadata. header returns the request header of
+// the given name.
+func (w *EventSubWebhook) Verify(header func(name string) string, body []byte) (EventSubMetadata, error) {
+	if w.Secret == "" {
+		return EventSubMetadata{}, errors.New("EVENTSUB_WEBHOOK_SECRET is not set")
+	}
+	m := EventSubMetadata{
+		MessageID:           header(EventSubHeaderMessageID),
+		MessageType:         header(EventSubHeaderMessageType),
+		SubscriptionType:    header(EventSubHeaderSubscriptionType),
+		SubscriptionVersion: header(EventSubHeaderSubscriptionVersion),
+	}
+	timestamp := header(EventSubHeaderTimestamp)
+	want := SignEventSub(w.Secret, m.MessageID, timestamp, body)
+	if !hmac.Equal([]byte(want), []byte(header(EventSubHeaderSignature))) {
+		return m, ErrEventSubSignature
+	}
+	ts, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return m, fmt.Errorf("%w: %v", ErrEventSubStale, err)
+	}
+	if age := time.Since(ts); age > eventSubDedupWindow || age < -eventSubDedupWindow {
+		return m, fmt.Errorf("%w: sent %s ago", ErrEventSubStale, age.Round(time.Second))
+	}
+	m.MessageTimestamp = ts
+	return m, nil
+}
+
+// Handle handles a verified webhook message. For verification requests it
+// returns the challenge the callback must answer with; they are answered
+// every time, as Twitch only resends them if the answer got lost.
+// Revocations that can be recovered from, e.g. after too many failed
+// deliveries, make Run subscribe again. Messages that cannot be parsed are not remembered, so Twitch's retries of them are
+// handled again.
+func (w *EventSubWebhook) Handle(m EventSubMetadata, body []byte) (string, error) {
+	var p EventSubPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return "", fmt.Errorf("failed to unmarshal EventSub message: %w", err)
+	}
+	if m.MessageType != EventSubVerification && !w.seen.add(m.MessageID) {
+		return "", ErrEventSubDuplicate
+	}
+	switch m.MessageType {
+	case EventSubVerification:
+		if p.Subscription != nil {
+			log.Printf("[EVENTSUB] verified webhook subscription %s to %s", p.Subscription.ID, p.Subscription.Type)
+		}
+		return p.Challenge, nil
+	case EventSubNotification:
+		w.bus.Publish(p.notification(m))
+	case EventSubRevocation:
+		if sub := p.Subscription; sub != nil {
+			log.Printf("[EVENTSUB] %s subscription %s revoked: %s", sub.Type, sub.ID, sub.Status)
+			if eventSubResubscribable(sub.Status) {
+				select {
+				case w.retry <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+	return "", nil
+}
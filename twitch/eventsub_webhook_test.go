@@ -0,0 +1,213 @@
+package twitch
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go-twitch/events"
+)
+
+const testEventSubSecret = "s3cr3t-for-tests"
+
+// eventSubFixture reads a webhook body from fixtures/eventsub.
+func eventSubFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("..", "fixtures", "eventsub", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// eventSubHeaders returns the headers Twitch sends with body, signed with
+// secret.
+func eventSubHeaders(secret, id, typ string, sent time.Time, body []byte) func(string) string {
+	timestamp := sent.UTC().Format(time.RFC3339Nano)
+	h := map[string]string{
+		EventSubHeaderMessageID:           id,
+		EventSubHeaderMessageType:         typ,
+		EventSubHeaderTimestamp:           timestamp,
+		EventSubHeaderSignature:           SignEventSub(secret, id, timestamp, body),
+		EventSubHeaderSubscriptionType:    "channel.follow",
+		EventSubHeaderSubscriptionVersion: "2",
+	}
+	return func(name string) string { return h[name] }
+}
+
+func newTestEventSubWebhook(bus *events.Bus) *EventSubWebhook {
+	return &EventSubWebhook{Secret: testEventSubSecret, bus: bus, seen: newMessageIDs(eventSubDedupWindow), retry: make(chan struct{}, 1)}
+}
+
+func TestEventSubWebhookVerify(t *testing.T) {
+	body := eventSubFixture(t, "channel.follow")
+	tests := []struct {
+		name    string
+		secret  string
+		sent    time.Time
+		body    []byte
+		replay  bool
+		wantErr error
+	}{
+		{name: "valid signature", secret: testEventSubSecret, sent: time.Now(), body: body},
+		{name: "bad signature", secret: "some-other-secret", sent: time.Now(), body: body, wantErr: ErrEventSubSignature},
+		{name: "tampered body", secret: testEventSubSecret, sent: time.Now(), body: append([]byte(" "), body...), wantErr: ErrEventSubSignature},
+		{name: "older than 10 minutes", secret: testEventSubSecret, sent: time.Now().Add(-11 * time.Minute), body: body, wantErr: ErrEventSubStale},
+		{name: "replayed ID", secret: testEventSubSecret, sent: time.Now(), body: body, replay: true, wantErr: ErrEventSubDuplicate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestEventSubWebhook(events.NewBus())
+			header := eventSubHeaders(tt.secret, "msg-1", EventSubNotification, tt.sent, body)
+			if tt.replay {
+				m, err := w.Verify(header, tt.body)
+				if err != nil {
+					t.Fatalf("first delivery: Verify: %v", err)
+				}
+				if _, err := w.Handle(m, tt.body); err != nil {
+					t.Fatalf("first delivery: Handle: %v", err)
+				}
+			}
+			m, err := w.Verify(header, tt.body)
+			if err == nil {
+				if m.MessageID != "msg-1" || m.MessageType != EventSubNotification {
+					t.Errorf("metadata = %+v", m)
+				}
+				_, err = w.Handle(m, tt.body)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventSubWebhookHandle(t *testing.T) {
+	tests := []struct {
+		name          string
+		fixture       string
+		messageType   string
+		wantChallenge string
+		wantEvent     *events.EventSubNotification
+	}{
+		{
+			name:          "verification challenge echo",
+			fixture:       "verification",
+			messageType:   EventSubVerification,
+			wantChallenge: "pogchamp-kappa-360noscope-vohiyo",
+		},
+		{
+			name:        "revocation",
+			fixture:     "revocation",
+			messageType: EventSubRevocation,
+		},
+		{
+			name:        "notification",
+			fixture:     "channel.follow",
+			messageType: EventSubNotification,
+			wantEvent: &events.EventSubNotification{
+				Header:    events.Header{Channel: "cooler_user"},
+				MessageID: "msg-1",
+				Type:      "channel.follow",
+				Version:   "2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := events.NewBus()
+			sub := bus.Subscribe(events.Filter{}, 0)
+			defer sub.Close()
+			w := newTestEventSubWebhook(bus)
+
+			body := eventSubFixture(t, tt.fixture)
+			m, err := w.Verify(eventSubHeaders(testEventSubSecret, "msg-1", tt.messageType, time.Now(), body), body)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			challenge, err := w.Handle(m, body)
+			if err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+			if challenge != tt.wantChallenge {
+				t.Errorf("challenge = %q, want %q", challenge, tt.wantChallenge)
+			}
+
+			select {
+			case e := <-sub.C:
+				n, ok := e.(events.EventSubNotification)
+				if tt.wantEvent == nil || !ok {
+					t.Fatalf("unexpected event %#v", e)
+				}
+				if n.Channel != tt.wantEvent.Channel || n.MessageID != tt.wantEvent.MessageID ||
+					n.Type != tt.wantEvent.Type || n.Version != tt.wantEvent.Version {
+					t.Errorf("event = %+v, want %+v", n, tt.wantEvent)
+				}
+				if len(n.Event) == 0 {
+					t.Error("event payload is empty")
+				}
+			default:
+				if tt.wantEvent != nil {
+					t.Error("no event published")
+				}
+			}
+		})
+	}
+}
+
+func TestEventSubWebhookHandleMalformedIsRetried(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe(events.Filter{}, 0)
+	defer sub.Close()
+	w := newTestEventSubWebhook(bus)
+
+	body := eventSubFixture(t, "channel.follow")
+	m := EventSubMetadata{MessageID: "msg-1", MessageType: EventSubNotification}
+	if _, err := w.Handle(m, body[:len(body)/2]); err == nil {
+		t.Fatal("truncated body: want error")
+	}
+	if _, err := w.Handle(m, body); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	select {
+	case <-sub.C:
+	default:
+		t.Error("retry was not published")
+	}
+}
+
+func TestEventSubWebhookRevocationRetry(t *testing.T) {
+	tests := []struct {
+		status    string
+		wantRetry bool
+	}{
+		{status: "authorization_revoked"},
+		{status: "user_removed"},
+		{status: "notification_failures_exceeded", wantRetry: true},
+	}
+	body := eventSubFixture(t, "revocation")
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			w := newTestEventSubWebhook(events.NewBus())
+			body := bytes.Replace(body, []byte(`"authorization_revoked"`), []byte(strconv.Quote(tt.status)), 1)
+			m := EventSubMetadata{MessageID: "msg-1", MessageType: EventSubRevocation}
+			if _, err := w.Handle(m, body); err != nil {
+				t.Fatalf("Handle: %v", err)
+			}
+			select {
+			case <-w.retry:
+				if !tt.wantRetry {
+					t.Error("retry poked")
+				}
+			default:
+				if tt.wantRetry {
+					t.Error("retry not poked")
+				}
+			}
+		})
+	}
+}
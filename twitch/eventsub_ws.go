@@ -0,0 +1,300 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-twitch/config"
+	"go-twitch/events"
+
+	"github.com/fasthttp/websocket"
+)
+
+// DefaultEventSubWSURL is the production EventSub WebSocket endpoint.
+const DefaultEventSubWSURL = "wss://eventsub.wss.twitch.tv/ws"
+
+const (
+	// eventSubKeepaliveGrace is added to the session's keepalive timeout
+	// before a silent connection is considered dead.
+	eventSubKeepaliveGrace = 5 * time.Second
+	// eventSubRetryMin and eventSubRetryMax bound the backoff between
+	// failed connection and subscription attempts.
+	eventSubRetryMin = time.Second
+	eventSubRetryMax = 2 * time.Minute
+)
+
+// errNoSubscriptions ends a session whose subscriptions were all revoked or
+// failed to be created.
+var errNoSubscriptions = errors.New("no subscriptions left")
+
+// EventSubWS receives EventSub notifications over the WebSocket transport
+// and publishes them on the bus. WebSocket subscriptions are created with
+// user tokens, so every account gets its own session, holding the
+// subscriptions of the channels it acts for. Sessions are connected when
+// their first subscription is added and recreate their subscriptions
+// whenever they have to start over. An EventSubWS is safe for concurrent
+// use.
+type EventSubWS struct {
+	// URL is the WebSocket endpoint; point it at the Twitch CLI's mock
+	// server, together with the Client's BaseURL, in tests.
+	URL string
+
+	helix    *Client
+	accounts *Accounts
+	bus      *events.Bus
+	seen     *messageIDs
+	channels []string
+	types    []string
+
+	// retry is poked when subscriptions fail to be created, for Run to
+	// subscribe the configured channels again.
+	retry chan struct{}
+
+	mu       sync.Mutex
+	sessions map[*TokenManager]*eventSubWSSession
+	// ctx is set by Run; sessions are connected under it.
+	ctx context.Context
+}
+
+// eventSubWSSession is the session of one account.
+type eventSubWSSession struct {
+	account *TokenManager
+	// subs are the subscriptions wanted on the session.
+	subs []CreateEventSubSubscriptionRequest
+	// id is the current session ID, empty while connecting.
+	id string
+}
+
+// NewEventSubWS returns an EventSubWS publishing on bus, subscribing the
+// channels and types configured in cfg once Run is called.
+func NewEventSubWS(cfg config.Config, helix *Client, accounts *Accounts, bus *events.Bus) *EventSubWS {
+	url := cfg.EventSubWSURL
+	if url == "" {
+		url = DefaultEventSubWSURL
+	}
+	types := cfg.EventSubTypes
+	if len(types) == 0 {
+		types = DefaultEventSubTypes
+	}
+	return &EventSubWS{
+		URL:      url,
+		helix:    helix,
+		accounts: accounts,
+		bus:      bus,
+		seen:     newMessageIDs(eventSubDedupWindow),
+		channels: cfg.EventSubChannels,
+		types:    types,
+		retry:    make(chan struct{}, 1),
+		sessions: make(map[*TokenManager]*eventSubWSSession),
+		ctx:      context.Background(),
+	}
+}
+
+// Run subscribes the configured channels and keeps the sessions connected
+// until ctx is cancelled. Channels whose subscriptions cannot be created,
+// e.g. because no account acting for them is authorized yet, are retried
+// with backoff.
+func (w *EventSubWS) Run(ctx context.Context) {
+	w.mu.Lock()
+	w.ctx = ctx
+	w.mu.Unlock()
+	subscribeChannels(ctx, w.channels, func(channel string) error {
+		return w.Subscribe(ctx, channel, w.types...)
+	}, w.retry)
+}
+
+// Subscribe subscribes to the given types of events in channel, on the
+// session of the account acting for it: the broadcaster, else a moderator.
+// Subscriptions are created right away if the session is connected and
+// once it is otherwise.
+func (w *EventSubWS) Subscribe(ctx context.Context, channel string, types ...string) error {
+	account, broadcasterID, err := eventSubTarget(ctx, w.helix, w.accounts, normalizeChannel(channel))
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	s, ok := w.sessions[account]
+	if !ok {
+		s = &eventSubWSSession{account: account}
+		w.sessions[account] = s
+	}
+	var added []CreateEventSubSubscriptionRequest
+	for _, typ := range types {
+		req := eventSubRequest(strings.TrimSpace(typ), broadcasterID, account.Current().UserID)
+		if !slices.ContainsFunc(s.subs, func(r CreateEventSubSubscriptionRequest) bool { return sameSubscription(r, req) }) {
+			s.subs = append(s.subs, req)
+			added = append(added, req)
+		}
+	}
+	sessionID := s.id
+	runCtx := w.ctx
+	w.mu.Unlock()
+
+	if !ok {
+		go w.run(runCtx, s)
+	}
+	if sessionID == "" {
+		return nil
+	}
+	return w.create(ctx, s, sessionID, added)
+}
+
+// create creates reqs on the session with the given ID. Requests that fail
+// are dropped from the session, so a new session does not retry them, and
+// left for Run to subscribe again.
+func (w *EventSubWS) create(ctx context.Context, s *eventSubWSSession, sessionID string, reqs []CreateEventSubSubscriptionRequest) error {
+	var errs []error
+	for _, req := range reqs {
+		req.Transport = EventSubTransport{Method: "websocket", SessionID: sessionID}
+		sub, err := w.helix.CreateEventSubSubscription(WithUserToken(ctx, s.account), req)
+		if err != nil {
+			errs = append(errs, err)
+			w.remove(s, req)
+			continue
+		}
+		log.Printf("[EVENTSUB] subscribed to %s as %s (%s)", sub.Type, s.account.Current().Login, sub.ID)
+	}
+	if len(errs) > 0 {
+		select {
+		case w.retry <- struct{}{}:
+		default:
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// remove drops the subscription req from s and reports whether any are left.
+func (w *EventSubWS) remove(s *eventSubWSSession, req CreateEventSubSubscriptionRequest) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s.subs = slices.DeleteFunc(s.subs, func(r CreateEventSubSubscriptionRequest) bool { return sameSubscription(r, req) })
+	return len(s.subs) > 0
+}
+
+// run keeps s connected until ctx is cancelled or s has no subscriptions
+// left. Connections Twitch asks to move follow it to the reconnect URL and
+// keep their subscriptions; any other new connection starts a new session
+// and recreates them.
+func (w *EventSubWS) run(ctx context.Context, s *eventSubWSSession) {
+	retry := eventSubRetryMin
+	var conn *websocket.Conn
+	var session *EventSubSession
+	for {
+		if conn == nil {
+			w.mu.Lock()
+			s.id = ""
+			if len(s.subs) == 0 || ctx.Err() != nil {
+				delete(w.sessions, s.account)
+				w.mu.Unlock()
+				return
+			}
+			w.mu.Unlock()
+
+			var err error
+			if conn, session, err = w.dial(ctx, w.URL); err != nil {
+				log.Printf("[EVENTSUB] %v; retrying in %s", err, retry)
+				if sleep(ctx, retry) != nil {
+					return
+				}
+				retry = min(retry*2, eventSubRetryMax)
+				continue
+			}
+			w.mu.Lock()
+			s.id = session.ID
+			reqs := slices.Clone(s.subs)
+			w.mu.Unlock()
+			go func(id string) {
+				ctx, cancel := context.WithTimeout(ctx, eventSubCreateTimeout)
+				defer cancel()
+				if err := w.create(ctx, s, id, reqs); err != nil {
+					log.Printf("[EVENTSUB] %v", err)
+				}
+			}(session.ID)
+		}
+		retry = eventSubRetryMin
+
+		c := conn
+		stop := context.AfterFunc(ctx, func() { c.Close() })
+		reconnectURL, err := w.read(s, conn, session)
+		stop()
+		if reconnectURL == "" {
+			conn.Close()
+			conn = nil
+			if ctx.Err() == nil {
+				log.Printf("[EVENTSUB] session of %s ended: %v", s.account.Current().Login, err)
+			}
+			continue
+		}
+		// Twitch keeps the old connection open until the new one is
+		// welcomed; the session and its subscriptions carry over.
+		next, nextSession, err := w.dial(ctx, reconnectURL)
+		conn.Close()
+		conn, session = next, nextSession
+		if err != nil {
+			log.Printf("[EVENTSUB] reconnecting %s: %v", s.account.Current().Login, err)
+		}
+	}
+}
+
+// dial connects to url and waits for the session welcome.
+func (w *EventSubWS) dial(ctx context.Context, url string) (*websocket.Conn, *EventSubSession, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+	var msg eventSubMessage
+	conn.SetReadDeadline(time.Now().Add(eventSubCreateTimeout))
+	if err := conn.ReadJSON(&msg); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to read welcome: %w", err)
+	}
+	if msg.Metadata.MessageType != EventSubWelcome || msg.Payload.Session == nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("expected %s, got %s", EventSubWelcome, msg.Metadata.MessageType)
+	}
+	return conn, msg.Payload.Session, nil
+}
+
+// read handles the messages of conn until it fails or Twitch asks to
+// reconnect, returning the URL to reconnect to.
+func (w *EventSubWS) read(s *eventSubWSSession, conn *websocket.Conn, session *EventSubSession) (string, error) {
+	keepalive := time.Duration(session.KeepaliveTimeoutSeconds)*time.Second + eventSubKeepaliveGrace
+	for {
+		conn.SetReadDeadline(time.Now().Add(keepalive))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return "", err
+		}
+		var msg eventSubMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Printf("[EVENTSUB] invalid message: %v", err)
+			continue
+		}
+		if msg.Metadata.MessageType != EventSubKeepalive && !w.seen.add(msg.Metadata.MessageID) {
+			continue
+		}
+		switch msg.Metadata.MessageType {
+		case EventSubNotification:
+			w.bus.Publish(msg.Payload.notification(msg.Metadata))
+		case EventSubReconnect:
+			if msg.Payload.Session != nil && msg.Payload.Session.ReconnectURL != "" {
+				return msg.Payload.Session.ReconnectURL, nil
+			}
+		case EventSubRevocation:
+			if sub := msg.Payload.Subscription; sub != nil {
+				log.Printf("[EVENTSUB] %s subscription %s revoked: %s", sub.Type, sub.ID, sub.Status)
+				if !w.remove(s, CreateEventSubSubscriptionRequest{Type: sub.Type, Version: sub.Version, Condition: sub.Condition}) {
+					return "", errNoSubscriptions
+				}
+			}
+		}
+	}
+}
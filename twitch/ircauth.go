@@ -0,0 +1,108 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	irc "github.com/gempir/go-twitch-irc/v4"
+)
+
+// ChatAuth creates IRC clients logged in as the account chosen for a channel
+// and keeps the credentials of every live client in sync with its token.
+type ChatAuth struct {
+	Accounts *Accounts
+
+	mu      sync.Mutex
+	clients map[*irc.Client]*TokenManager
+	// watched holds the managers whose OnChange already updates clients.
+	watched map[*TokenManager]bool
+}
+
+// NewChatAuth returns a ChatAuth picking accounts from accounts.
+func NewChatAuth(accounts *Accounts) *ChatAuth {
+	a := &ChatAuth{
+		Accounts: accounts,
+		clients:  make(map[*irc.Client]*TokenManager),
+		watched:  make(map[*TokenManager]bool),
+	}
+	accounts.OnRemove(func(m *TokenManager) {
+		a.mu.Lock()
+		delete(a.watched, m)
+		a.mu.Unlock()
+	})
+	return a
+}
+
+// Account returns the bot account that speaks in channel.
+func (a *ChatAuth) Account(channel string) (*TokenManager, error) {
+	return a.Accounts.ForChannel(channel, RoleBot)
+}
+
+// clientFor returns an IRC client logged in as m. The client receives new
+// tokens until it is forgotten.
+func (a *ChatAuth) clientFor(m *TokenManager) (*irc.Client, error) {
+	token, err := m.Token()
+	if err != nil {
+		return nil, err
+	}
+	client := irc.NewClient(m.Current().Login, "oauth:"+token)
+	a.mu.Lock()
+	a.clients[client] = m
+	if !a.watched[m] {
+		a.watched[m] = true
+		m.OnChange(func(t Token) { a.updateClients(m, t) })
+	}
+	a.mu.Unlock()
+	return client, nil
+}
+
+// Connect connects client and blocks until it disconnects. If Twitch rejects
+// the login, the user token is refreshed and the connection retried once.
+func (a *ChatAuth) Connect(client *irc.Client) error {
+	a.mu.Lock()
+	m := a.clients[client]
+	a.mu.Unlock()
+	// Token fails once the manager marks the token invalid, so remember the
+	// one the client logs in with for Invalidate to refresh.
+	var loggedIn string
+	if m != nil {
+		loggedIn = m.Current().AccessToken
+	}
+	err := client.Connect()
+	if !errors.Is(err, irc.ErrLoginAuthenticationFailed) || m == nil {
+		return err
+	}
+	token, refreshErr := m.Invalidate(context.Background(), loggedIn)
+	if refreshErr != nil {
+		log.Printf("[IRC] login failed and token refresh failed: %v", refreshErr)
+		return err
+	}
+	client.SetIRCToken("oauth:" + token)
+	return client.Connect()
+}
+
+func (a *ChatAuth) forget(client *irc.Client) {
+	a.mu.Lock()
+	delete(a.clients, client)
+	a.mu.Unlock()
+}
+
+// updateClients hands a renewed token of m to every live client logged in
+// as it, used on the client's next (re)connect. When the token was cleared,
+// those clients are disconnected instead.
+func (a *ChatAuth) updateClients(m *TokenManager, t Token) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for client, owner := range a.clients {
+		if owner != m {
+			continue
+		}
+		if t.AccessToken == "" {
+			client.Disconnect()
+			continue
+		}
+		client.SetIRCToken("oauth:" + t.AccessToken)
+	}
+}
@@ -0,0 +1,236 @@
+package twitch
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go-twitch/events"
+
+	irc "github.com/gempir/go-twitch-irc/v4"
+)
+
+// Conversions from go-twitch-irc messages to bus events.
+
+func chatMessageEvent(m irc.PrivateMessage) events.ChatMessage {
+	badges := m.User.Badges
+	if badges == nil {
+		badges = map[string]int{}
+	}
+	emotes := make([]events.Emote, 0, len(m.Emotes))
+	for _, e := range m.Emotes {
+		emote := events.Emote{ID: e.ID, Name: e.Name}
+		for _, p := range e.Positions {
+			emote.Positions = append(emote.Positions, events.EmotePosition{Start: p.Start, End: p.End})
+		}
+		emotes = append(emotes, emote)
+	}
+	return events.ChatMessage{
+		Header:       events.Header{Channel: m.Channel, Time: eventTime(m.Time)},
+		ID:           m.ID,
+		ChannelID:    m.RoomID,
+		UserID:       m.User.ID,
+		User:         m.User.Name,
+		DisplayName:  m.User.DisplayName,
+		Color:        m.User.Color,
+		Badges:       badges,
+		Message:      m.Message,
+		Action:       m.Action,
+		Emotes:       emotes,
+		Bits:         m.Bits,
+		FirstMessage: m.FirstMessage,
+		Reply:        replyOf(m),
+	}
+}
+
+// replyOf returns the reply metadata of m, or nil if it is no reply.
+func replyOf(m irc.PrivateMessage) *events.Reply {
+	if m.Reply == nil {
+		return nil
+	}
+	return &events.Reply{
+		ParentMsgID:           m.Reply.ParentMsgID,
+		ParentUserID:          m.Reply.ParentUserID,
+		ParentUserLogin:       m.Reply.ParentUserLogin,
+		ParentDisplayName:     m.Reply.ParentDisplayName,
+		ParentMsgBody:         m.Reply.ParentMsgBody,
+		ThreadParentMsgID:     m.Tags["reply-thread-parent-msg-id"],
+		ThreadParentUserLogin: m.Tags["reply-thread-parent-user-login"],
+	}
+}
+
+func noticeEvent(m irc.NoticeMessage) events.Notice {
+	return events.Notice{
+		Header:  events.Header{Channel: m.Channel, Time: time.Now()},
+		MsgID:   m.MsgID,
+		Message: m.Message,
+	}
+}
+
+// anonymousGifter is the login of anonymous gifts.
+const anonymousGifter = "ananonymousgifter"
+
+// userNoticeEvent returns the typed event of m, or a plain UserNotice for
+// notices of other types.
+func userNoticeEvent(m irc.UserNoticeMessage) events.Event {
+	n := events.UserNotice{
+		Header:      events.Header{Channel: m.Channel, Time: eventTime(m.Time)},
+		Type:        events.UserNoticeOther,
+		ID:          m.ID,
+		ChannelID:   m.RoomID,
+		MsgID:       m.MsgID,
+		UserID:      m.User.ID,
+		User:        m.User.Name,
+		DisplayName: m.User.DisplayName,
+		SystemMsg:   m.SystemMsg,
+		Message:     m.Message,
+		Params:      m.MsgParams,
+	}
+	param := func(name string) string { return m.MsgParams["msg-param-"+name] }
+	intParam := func(name string) int {
+		v, _ := strconv.Atoi(param(name))
+		return v
+	}
+	anonymous := strings.HasPrefix(m.MsgID, "anon") || m.User.Name == anonymousGifter
+
+	switch m.MsgID {
+	case "sub", "resub":
+		n.Type = m.MsgID
+		return events.Sub{
+			UserNotice:         n,
+			Plan:               param("sub-plan"),
+			PlanName:           param("sub-plan-name"),
+			CumulativeMonths:   intParam("cumulative-months"),
+			StreakMonths:       intParam("streak-months"),
+			MultiMonthDuration: intParam("multimonth-duration"),
+		}
+	case "subgift", "anonsubgift":
+		n.Type = events.UserNoticeSubGift
+		return events.SubGift{
+			UserNotice:           n,
+			Plan:                 param("sub-plan"),
+			PlanName:             param("sub-plan-name"),
+			RecipientID:          param("recipient-id"),
+			RecipientUser:        param("recipient-user-name"),
+			RecipientDisplayName: param("recipient-display-name"),
+			Months:               intParam("months"),
+			GiftMonths:           intParam("gift-months"),
+			SenderCount:          intParam("sender-count"),
+			Anonymous:            anonymous,
+			CommunityGiftID:      param("community-gift-id"),
+		}
+	case "submysterygift", "anonsubmysterygift":
+		n.Type = events.UserNoticeMysteryGift
+		return events.MysteryGift{
+			UserNotice:      n,
+			Plan:            param("sub-plan"),
+			Count:           intParam("mass-gift-count"),
+			SenderCount:     intParam("sender-count"),
+			Anonymous:       anonymous,
+			CommunityGiftID: param("community-gift-id"),
+		}
+	case "giftpaidupgrade", "anongiftpaidupgrade", "primepaidupgrade":
+		n.Type = events.UserNoticeGiftUpgrade
+		return events.GiftUpgrade{
+			UserNotice:        n,
+			Plan:              param("sub-plan"),
+			SenderUser:        param("sender-login"),
+			SenderDisplayName: param("sender-name"),
+			Anonymous:         m.MsgID == "anongiftpaidupgrade",
+		}
+	case "raid":
+		n.Type = events.UserNoticeRaid
+		return events.Raid{
+			UserNotice:      n,
+			ViewerCount:     intParam("viewerCount"),
+			ProfileImageURL: param("profileImageURL"),
+		}
+	case "announcement":
+		n.Type = events.UserNoticeAnnouncement
+		return events.Announcement{UserNotice: n, Color: param("color")}
+	case "bitsbadgetier":
+		n.Type = events.UserNoticeBitsBadgeTier
+		return events.BitsBadgeTier{UserNotice: n, Threshold: intParam("threshold")}
+	}
+	return n
+}
+
+func clearChatEvent(m irc.ClearChatMessage) events.ClearChat {
+	e := events.ClearChat{
+		Header:       events.Header{Channel: m.Channel, Time: eventTime(m.Time)},
+		ChannelID:    m.RoomID,
+		Action:       events.ClearChatClear,
+		TargetUserID: m.TargetUserID,
+		TargetUser:   m.TargetUsername,
+		BanDuration:  m.BanDuration,
+	}
+	switch {
+	case m.TargetUsername == "":
+	case m.BanDuration > 0:
+		e.Action = events.ClearChatTimeout
+	default:
+		e.Action = events.ClearChatBan
+	}
+	return e
+}
+
+func clearMsgEvent(m irc.ClearMessage) events.ClearMsg {
+	return events.ClearMsg{
+		Header:      events.Header{Channel: m.Channel, Time: eventTime(tmiSentTime(m.Tags))},
+		TargetMsgID: m.TargetMsgID,
+		User:        m.Login,
+		Message:     m.Message,
+	}
+}
+
+// roomStateEvent returns the ROOMSTATE m applied to the channel's previous
+// settings, which are nil for the state received on joining.
+func roomStateEvent(m irc.RoomStateMessage, previous *events.RoomSettings) events.RoomState {
+	settings := events.RoomSettings{FollowersOnly: -1}
+	if previous != nil {
+		settings = *previous
+	}
+	for mode, v := range m.State {
+		switch mode {
+		case "emote-only":
+			settings.EmoteOnly = v == 1
+		case "followers-only":
+			settings.FollowersOnly = v
+		case "r9k":
+			settings.R9K = v == 1
+		case "slow":
+			settings.Slow = v
+		case "subs-only":
+			settings.SubsOnly = v == 1
+		}
+	}
+	changed := []string{}
+	if previous != nil {
+		changed = settings.Diff(*previous)
+	}
+	return events.RoomState{
+		Header:    events.Header{Channel: m.Channel, Time: time.Now()},
+		ChannelID: m.RoomID,
+		Settings:  settings,
+		Previous:  previous,
+		Changed:   changed,
+	}
+}
+
+// tmiSentTime parses the tmi-sent-ts tag, which go-twitch-irc does not for
+// every message type.
+func tmiSentTime(tags map[string]string) time.Time {
+	ms, err := strconv.ParseInt(tags["tmi-sent-ts"], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// eventTime is the server timestamp of a message, or now if it had none.
+func eventTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
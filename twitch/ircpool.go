@@ -0,0 +1,582 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go-twitch/emotes"
+	"go-twitch/events"
+
+	irc "github.com/gempir/go-twitch-irc/v4"
+)
+
+const (
+	// ircChannelsPerConn caps the channels joined over one connection; more
+	// channels for the same account open another connection.
+	ircChannelsPerConn = 50
+	// ircReconnectDelay is the pause before reconnecting a dropped connection.
+	ircReconnectDelay = 5 * time.Second
+)
+
+// IRCPool shares Twitch IRC connections between everything in the process
+// that reads or writes chat. Channels are joined over a small pool of
+// connections per account and left again when their last subscriber goes
+// away. Every message is published on the event bus, from where it reaches
+// all subscribers of its channel. Joins of one account share a rate limiter
+// across its connections, as Twitch limits joins per account, and so do its
+// outgoing messages, which go through one chatQueue per account.
+type IRCPool struct {
+	// Emotes resolves third-party emotes when chat messages are split into
+	// fragments. Without it only Twitch emotes are recognized.
+	Emotes *emotes.Resolver
+
+	auth *ChatAuth
+	bus  *events.Bus
+
+	mu       sync.Mutex
+	conns    map[*TokenManager][]*ircConn
+	limiters map[*TokenManager]*irc.WindowRateLimiter
+	queues   map[*TokenManager]*chatQueue
+	channels map[string]*ircChannel
+	// relays are the subscriptions started by /irc/subscribe.
+	relays map[string]*IRCSubscription
+}
+
+type ircConn struct {
+	client   *irc.Client
+	account  *TokenManager
+	channels map[string]bool
+	// closed is set once the pool no longer wants this connection.
+	closed bool
+}
+
+type ircChannel struct {
+	conn *ircConn
+	subs map[*IRCSubscription]struct{}
+	// privileged is set while the account is moderator, VIP or broadcaster
+	// in the channel, as reported by USERSTATE.
+	privileged bool
+	// room is the channel's chat settings, as reported by ROOMSTATE.
+	room *events.RoomSettings
+	// slow is the channel's slow mode delay.
+	slow time.Duration
+	// joined is set once the connection's JOIN was acknowledged.
+	joined bool
+	// awaiting are the messages written to the channel that Twitch has not
+	// yet confirmed or rejected, oldest first.
+	awaiting []*outgoing
+}
+
+// IRCSubscription keeps a channel joined and receives its events from the
+// bus until Close is called.
+type IRCSubscription struct {
+	Channel string
+	C       <-chan events.Event
+
+	events *events.Subscription
+	pool   *IRCPool
+	closed bool
+}
+
+// NewIRCPool returns a pool logging in with the accounts chosen by auth and
+// publishing chat events on bus.
+func NewIRCPool(auth *ChatAuth, bus *events.Bus) *IRCPool {
+	p := &IRCPool{
+		auth:     auth,
+		bus:      bus,
+		conns:    make(map[*TokenManager][]*ircConn),
+		limiters: make(map[*TokenManager]*irc.WindowRateLimiter),
+		queues:   make(map[*TokenManager]*chatQueue),
+		channels: make(map[string]*ircChannel),
+		relays:   make(map[string]*IRCSubscription),
+	}
+	auth.Accounts.OnRemove(p.forgetAccount)
+	return p
+}
+
+// forgetAccount closes the connections of a removed account, moving their
+// channels to other accounts, and drops its join limiter and send queue.
+func (p *IRCPool) forgetAccount(account *TokenManager) {
+	p.mu.Lock()
+	for _, conn := range slices.Clone(p.conns[account]) {
+		p.closeConn(conn)
+		p.rehome(conn)
+	}
+	q := p.queues[account]
+	delete(p.queues, account)
+	delete(p.limiters, account)
+	p.mu.Unlock()
+	if q != nil {
+		q.close()
+	}
+}
+
+func normalizeChannel(channel string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(channel), "#"))
+}
+
+// Subscribe joins channel, unless it is already joined, and returns a
+// subscription to its chat events.
+func (p *IRCPool) Subscribe(channel string) (*IRCSubscription, error) {
+	channel = normalizeChannel(channel)
+	if channel == "" {
+		return nil, errors.New("missing channel name")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := p.channels[channel]
+	if ch == nil {
+		conn, err := p.connFor(channel)
+		if err != nil {
+			return nil, err
+		}
+		ch = &ircChannel{conn: conn, subs: make(map[*IRCSubscription]struct{})}
+		p.channels[channel] = ch
+		conn.channels[channel] = true
+		conn.client.Join(channel)
+	}
+	es := p.bus.Subscribe(events.Filter{Channel: channel}, 0)
+	sub := &IRCSubscription{Channel: channel, C: es.C, events: es, pool: p}
+	ch.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Close ends the subscription and closes C. The channel is left once it has
+// no subscribers.
+func (s *IRCSubscription) Close() {
+	s.pool.unsubscribe(s)
+}
+
+func (p *IRCPool) unsubscribe(sub *IRCSubscription) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	sub.events.Close()
+	ch := p.channels[sub.Channel]
+	if ch == nil {
+		return
+	}
+	delete(ch.subs, sub)
+	if len(ch.subs) > 0 {
+		return
+	}
+	delete(p.channels, sub.Channel)
+	conn := ch.conn
+	delete(conn.channels, sub.Channel)
+	conn.client.Depart(sub.Channel)
+	if len(conn.channels) == 0 {
+		p.closeConn(conn)
+	}
+}
+
+// connFor returns a connection of channel's bot account with room for
+// another channel, opening one if needed. Callers hold mu.
+func (p *IRCPool) connFor(channel string) (*ircConn, error) {
+	account, err := p.auth.Account(channel)
+	if err != nil {
+		return nil, err
+	}
+	for _, conn := range p.conns[account] {
+		if !conn.closed && len(conn.channels) < ircChannelsPerConn {
+			return conn, nil
+		}
+	}
+	client, err := p.auth.clientFor(account)
+	if err != nil {
+		return nil, err
+	}
+	limiter := p.limiters[account]
+	if limiter == nil {
+		limiter = irc.CreateDefaultRateLimiter()
+		p.limiters[account] = limiter
+	}
+	client.SetJoinRateLimiter(limiter)
+	client.Capabilities = []string{irc.TagsCapability, irc.CommandsCapability, irc.MembershipCapability}
+
+	conn := &ircConn{client: client, account: account, channels: make(map[string]bool)}
+	client.OnPrivateMessage(func(m irc.PrivateMessage) {
+		e := chatMessageEvent(m)
+		e.Fragments = p.Emotes.Fragments(e)
+		p.bus.Publish(e)
+	})
+	client.OnNoticeMessage(func(m irc.NoticeMessage) {
+		// msg_* notices reject the oldest message awaiting confirmation.
+		if strings.HasPrefix(m.MsgID, "msg_") {
+			p.confirm(conn, m.Channel, ChatSendResult{DropReason: &DropReason{Code: m.MsgID, Message: m.Message}})
+		}
+		p.bus.Publish(noticeEvent(m))
+	})
+	client.OnUserNoticeMessage(func(m irc.UserNoticeMessage) { p.bus.Publish(userNoticeEvent(m)) })
+	client.OnClearChatMessage(func(m irc.ClearChatMessage) { p.bus.Publish(clearChatEvent(m)) })
+	client.OnClearMessage(func(m irc.ClearMessage) { p.bus.Publish(clearMsgEvent(m)) })
+	client.OnRoomStateMessage(func(m irc.RoomStateMessage) {
+		var e events.RoomState
+		p.updateChannel(conn, m.Channel, func(ch *ircChannel) {
+			e = roomStateEvent(m, ch.room)
+			ch.room = &e.Settings
+			ch.slow = time.Duration(e.Settings.Slow) * time.Second
+		})
+		if e.Channel != "" {
+			p.bus.Publish(e)
+		}
+	})
+	client.OnUserStateMessage(func(m irc.UserStateMessage) {
+		privileged := m.User.Badges["moderator"] > 0 || m.User.Badges["vip"] > 0 || m.User.Badges["broadcaster"] > 0
+		p.updateChannel(conn, m.Channel, func(ch *ircChannel) { ch.privileged = privileged })
+		// The USERSTATE following a PRIVMSG carries the ID of the message.
+		if id := m.Tags["id"]; id != "" {
+			p.confirm(conn, m.Channel, ChatSendResult{IsSent: true, MessageID: id})
+		}
+	})
+	client.OnSelfJoinMessage(func(m irc.UserJoinMessage) {
+		p.updateChannel(conn, m.Channel, func(ch *ircChannel) { ch.joined = true })
+		p.mu.Lock()
+		if q := p.queues[conn.account]; q != nil {
+			q.poke()
+		}
+		p.mu.Unlock()
+	})
+	client.OnConnect(func() {
+		p.mu.Lock()
+		closed := conn.closed
+		// Channels are joined again after a reconnect. Messages written on
+		// the old connection are never confirmed; their timers finish them.
+		for channel := range conn.channels {
+			if ch := p.channels[channel]; ch != nil && ch.conn == conn {
+				ch.joined, ch.awaiting = false, nil
+			}
+		}
+		p.mu.Unlock()
+		if closed {
+			client.Disconnect()
+		}
+	})
+	p.conns[account] = append(p.conns[account], conn)
+	go p.run(conn)
+	return conn, nil
+}
+
+// updateChannel applies fn to channel if it is still joined over conn.
+func (p *IRCPool) updateChannel(conn *ircConn, channel string, fn func(*ircChannel)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ch := p.channels[channel]; ch != nil && ch.conn == conn {
+		fn(ch)
+	}
+}
+
+// channelState returns what the send queue needs to know about channel, or
+// false if it is not joined.
+func (p *IRCPool) channelState(channel string) (chatChannelState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := p.channels[channel]
+	if ch == nil {
+		return chatChannelState{}, false
+	}
+	return chatChannelState{joined: ch.joined, privileged: ch.privileged, slow: ch.slow}, true
+}
+
+// write sends text for m over the connection its channel is joined on and
+// waits for Twitch to confirm it. It reports false if the channel is not
+// joined.
+func (p *IRCPool) write(m *outgoing, text string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := p.channels[m.channel]
+	if ch == nil || !ch.joined {
+		return false
+	}
+	// Messages given up on long ago are no longer waited for.
+	for len(ch.awaiting) > 0 && !ch.awaiting[0].expired.IsZero() && time.Since(ch.awaiting[0].expired) >= chatLateConfirmWindow {
+		ch.awaiting = ch.awaiting[1:]
+	}
+	if m.replyTo != "" {
+		ch.conn.client.Reply(m.channel, m.replyTo, text)
+	} else {
+		ch.conn.client.Say(m.channel, text)
+	}
+	ch.awaiting = append(ch.awaiting, m)
+	time.AfterFunc(chatConfirmTimeout, func() { p.unconfirmed(m) })
+	return true
+}
+
+// confirm completes the oldest message awaiting confirmation in channel on
+// conn with r. IRC confirmations carry nothing to tell which message they
+// answer, so they are matched in order. A confirmation whose message already
+// timed out is dropped instead of completing the next message; messages
+// that timed out more than chatLateConfirmWindow ago are assumed lost.
+func (p *IRCPool) confirm(conn *ircConn, channel string, r ChatSendResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := p.channels[channel]
+	if ch == nil || ch.conn != conn {
+		return
+	}
+	now := time.Now()
+	for len(ch.awaiting) > 0 {
+		m := ch.awaiting[0]
+		ch.awaiting = ch.awaiting[1:]
+		if m.expired.IsZero() {
+			r.Transport, r.Channel, r.Account = ChatTransportIRC, m.channel, m.account
+			m.finish(r)
+			return
+		}
+		if now.Sub(m.expired) < chatLateConfirmWindow {
+			return
+		}
+	}
+}
+
+// unconfirmed gives up waiting for Twitch to confirm m. It stays awaiting,
+// so that a late confirmation is not taken for the next message's.
+func (p *IRCPool) unconfirmed(m *outgoing) {
+	p.mu.Lock()
+	m.expired = time.Now()
+	p.mu.Unlock()
+	m.finish(m.result(ReasonUnconfirmed, "Twitch did not confirm the message"))
+}
+
+// run keeps conn connected until the pool closes it. If the connection is
+// dropped because its account was logged out, its channels move to another
+// account's connection; other failures, including rejected logins that may
+// be fixed by re-authorizing, are retried after ircReconnectDelay.
+func (p *IRCPool) run(conn *ircConn) {
+	defer p.auth.forget(conn.client)
+	for {
+		p.mu.Lock()
+		if conn.closed {
+			p.mu.Unlock()
+			return
+		}
+		if conn.account.Current().AccessToken == "" {
+			// Logged out while the connection was down.
+			p.closeConn(conn)
+			p.rehome(conn)
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+
+		err := p.auth.Connect(conn.client)
+
+		p.mu.Lock()
+		if conn.closed {
+			p.mu.Unlock()
+			return
+		}
+		if errors.Is(err, irc.ErrClientDisconnected) {
+			p.closeConn(conn)
+			p.rehome(conn)
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+
+		log.Printf("[IRC] connection as %s dropped: %v; reconnecting in %s", conn.account.Current().Login, err, ircReconnectDelay)
+		time.Sleep(ircReconnectDelay)
+	}
+}
+
+// closeConn marks conn unwanted, disconnects it and drops it from the pool.
+// Callers hold mu.
+func (p *IRCPool) closeConn(conn *ircConn) {
+	conn.closed = true
+	conn.client.Disconnect()
+	conns := p.conns[conn.account]
+	for i, c := range conns {
+		if c == conn {
+			p.conns[conn.account] = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(p.conns[conn.account]) == 0 {
+		delete(p.conns, conn.account)
+	}
+}
+
+// rehome joins the channels of a dead connection over other connections.
+// Channels no account can join are dropped and their subscriptions closed.
+// Callers hold mu.
+func (p *IRCPool) rehome(dead *ircConn) {
+	for channel := range dead.channels {
+		ch := p.channels[channel]
+		if ch == nil || ch.conn != dead {
+			continue
+		}
+		conn, err := p.connFor(channel)
+		if err != nil {
+			log.Printf("[IRC] leaving #%s: %v", channel, err)
+			delete(p.channels, channel)
+			for sub := range ch.subs {
+				sub.closed = true
+				sub.events.Close()
+			}
+			delete(p.relays, channel)
+			continue
+		}
+		ch.conn = conn
+		ch.privileged, ch.room, ch.slow, ch.joined, ch.awaiting = false, nil, 0, false, nil
+		conn.channels[channel] = true
+		conn.client.Join(channel)
+	}
+}
+
+// Say queues message for channel, to be sent as the channel's bot account
+// once the channel is joined and Twitch's rate limits and the channel's slow
+// mode allow it. If nothing is subscribed to the channel yet, it is joined
+// first and stays joined as a relay. The returned stats describe the
+// account's queue after the call.
+func (p *IRCPool) Say(channel, message string) (ChatQueueStats, error) {
+	_, _, stats, err := p.enqueue(channel, message, "")
+	return stats, err
+}
+
+// Send is like Say but waits until Twitch confirmed or rejected the message,
+// or ctx is done. Unless replyTo is empty, the message is a reply to the
+// message with that ID. Delivery failures are reported in the result; the
+// error is only set if the message could not be queued at all.
+func (p *IRCPool) Send(ctx context.Context, channel, message, replyTo string) (ChatSendResult, error) {
+	m, q, stats, err := p.enqueue(channel, message, replyTo)
+	if errors.Is(err, ErrChatQueueFull) {
+		return ChatSendResult{
+			Transport:  ChatTransportIRC,
+			Channel:    normalizeChannel(channel),
+			Account:    stats.Account,
+			DropReason: &DropReason{Code: ReasonQueueFull, Message: err.Error()},
+			Queue:      &stats,
+		}, nil
+	}
+	if err != nil {
+		return ChatSendResult{}, err
+	}
+	var r ChatSendResult
+	select {
+	case r = <-m.done:
+	case <-ctx.Done():
+		if q.cancel(m) {
+			r = m.result(ReasonTimeout, "the message was not sent in time")
+		} else {
+			r = m.result(ReasonUnconfirmed, "Twitch did not confirm the message in time")
+		}
+	}
+	stats = q.stats()
+	r.Transport, r.Queue = ChatTransportIRC, &stats
+	return r, nil
+}
+
+func (p *IRCPool) enqueue(channel, message, replyTo string) (*outgoing, *chatQueue, ChatQueueStats, error) {
+	channel = normalizeChannel(channel)
+	p.mu.Lock()
+	ch := p.channels[channel]
+	p.mu.Unlock()
+	if ch == nil {
+		if _, err := p.StartRelay(channel); err != nil {
+			return nil, nil, ChatQueueStats{}, err
+		}
+	}
+	p.mu.Lock()
+	ch = p.channels[channel]
+	if ch == nil {
+		p.mu.Unlock()
+		return nil, nil, ChatQueueStats{}, fmt.Errorf("IRC client not available for channel %s", channel)
+	}
+	account := ch.conn.account
+	q := p.queues[account]
+	if q == nil {
+		q = newChatQueue(p, account)
+		p.queues[account] = q
+	}
+	p.mu.Unlock()
+	m, stats, err := q.push(channel, message, replyTo)
+	if err != nil {
+		log.Printf("[IRC] dropped message to #%s as %s: %v", channel, stats.Account, err)
+	}
+	return m, q, stats, err
+}
+
+// QueueStats returns the outgoing queue of every account that has sent chat,
+// sorted by login.
+func (p *IRCPool) QueueStats() []ChatQueueStats {
+	p.mu.Lock()
+	queues := make([]*chatQueue, 0, len(p.queues))
+	for _, q := range p.queues {
+		queues = append(queues, q)
+	}
+	p.mu.Unlock()
+	stats := make([]ChatQueueStats, 0, len(queues))
+	for _, q := range queues {
+		stats = append(stats, q.stats())
+	}
+	slices.SortFunc(stats, func(a, b ChatQueueStats) int { return strings.Compare(a.Account, b.Account) })
+	return stats
+}
+
+// Account returns the account Say sends as in channel.
+func (p *IRCPool) Account(channel string) (*TokenManager, error) {
+	return p.auth.Account(channel)
+}
+
+// StartRelay keeps channel joined until StopRelay, logging its notices. It
+// reports false if the channel was already relayed.
+func (p *IRCPool) StartRelay(channel string) (bool, error) {
+	channel = normalizeChannel(channel)
+	p.mu.Lock()
+	_, exists := p.relays[channel]
+	p.mu.Unlock()
+	if exists {
+		return false, nil
+	}
+	sub, err := p.Subscribe(channel)
+	if err != nil {
+		return false, err
+	}
+	p.mu.Lock()
+	if _, exists := p.relays[channel]; exists {
+		p.mu.Unlock()
+		sub.Close()
+		return false, nil
+	}
+	p.relays[channel] = sub
+	p.mu.Unlock()
+
+	go func() {
+		for e := range sub.C {
+			if n, ok := e.(events.Notice); ok {
+				log.Printf("[IRC][NOTICE][%s] %s", n.Channel, n.Message)
+			}
+		}
+	}()
+	return true, nil
+}
+
+// StopRelay ends the relay started by StartRelay. It reports false if the
+// channel was not relayed.
+func (p *IRCPool) StopRelay(channel string) bool {
+	channel = normalizeChannel(channel)
+	p.mu.Lock()
+	sub, exists := p.relays[channel]
+	delete(p.relays, channel)
+	p.mu.Unlock()
+	if exists {
+		sub.Close()
+	}
+	return exists
+}
+
+// Relaying reports whether channel is relayed.
+func (p *IRCPool) Relaying(channel string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, exists := p.relays[normalizeChannel(channel)]
+	return exists
+}
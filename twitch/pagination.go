@@ -0,0 +1,120 @@
+package twitch
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// Pagination is the cursor object Helix attaches to list responses.
+type Pagination struct {
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Page is a single page of a Helix list endpoint.
+type Page[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+	// Total is only reported by some endpoints (e.g. channel followers).
+	Total int `json:"total,omitempty"`
+}
+
+// PageParams are the standard Helix paging query parameters. Zero values are
+// omitted from the request. After and Before are mutually exclusive; when
+// Before is set the pager walks backwards.
+type PageParams struct {
+	First  int
+	After  string
+	Before string
+}
+
+// Pager walks a cursor-paginated Helix endpoint one page at a time:
+//
+//	p := client.TopGames(twitch.PageParams{First: 100})
+//	for p.More() {
+//		page, err := p.Next(ctx)
+//		...
+//	}
+type Pager[T any] struct {
+	client   *Client
+	mode     authMode
+	path     string
+	query    url.Values
+	cursor   string
+	backward bool
+	done     bool
+}
+
+func newPager[T any](c *Client, mode authMode, path string, query url.Values, params PageParams) *Pager[T] {
+	if query == nil {
+		query = url.Values{}
+	}
+	if params.First > 0 {
+		query.Set("first", strconv.Itoa(params.First))
+	}
+	p := &Pager[T]{client: c, mode: mode, path: path, query: query}
+	switch {
+	case params.Before != "":
+		p.cursor = params.Before
+		p.backward = true
+	case params.After != "":
+		p.cursor = params.After
+	}
+	return p
+}
+
+// More reports whether another call to Next may return data.
+func (p *Pager[T]) More() bool {
+	return !p.done
+}
+
+// Cursor returns the cursor the next call to Next will send.
+func (p *Pager[T]) Cursor() string {
+	return p.cursor
+}
+
+// Next fetches the next page. Once the endpoint stops returning a cursor,
+// More reports false and further calls return an empty page.
+func (p *Pager[T]) Next(ctx context.Context) (*Page[T], error) {
+	if p.done {
+		return &Page[T]{}, nil
+	}
+	query := url.Values{}
+	for k, v := range p.query {
+		query[k] = v
+	}
+	if p.cursor != "" {
+		if p.backward {
+			query.Set("before", p.cursor)
+		} else {
+			query.Set("after", p.cursor)
+		}
+	}
+
+	page, err := get[Page[T]](ctx, p.client, p.mode, p.path, query)
+	if err != nil {
+		return nil, err
+	}
+	p.cursor = page.Pagination.Cursor
+	if p.cursor == "" || len(page.Data) == 0 {
+		p.done = true
+	}
+	return page, nil
+}
+
+// Collect fetches pages until the endpoint is exhausted or max items have
+// been gathered. A max of zero or less means no limit.
+func (p *Pager[T]) Collect(ctx context.Context, max int) ([]T, error) {
+	var all []T
+	for p.More() {
+		page, err := p.Next(ctx)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, page.Data...)
+		if max > 0 && len(all) >= max {
+			return all[:max], nil
+		}
+	}
+	return all, nil
+}
@@ -0,0 +1,217 @@
+package twitch
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitWindow is how long a bucket whose reset time has passed is
+	// assumed to last until a response reports its state.
+	rateLimitWindow = time.Minute
+	// rateLimitMinWait is the shortest wait for an empty bucket, so one with
+	// a limit of 0 or a stale reset time is not polled in a busy loop.
+	rateLimitMinWait = 100 * time.Millisecond
+)
+
+// RetryPolicy controls how Helix requests are retried on 429, 502 and 503.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry; it doubles on each
+	// subsequent attempt, capped at MaxDelay, and is fully jittered.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// RateLimiter tracks Twitch's per-token rate limit buckets from the
+// Ratelimit-* response headers. When a bucket is empty, callers wait until
+// it resets instead of being rejected by Helix.
+type RateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	limit     int
+	remaining int
+	reset     time.Time
+	// inflight counts reserved requests whose responses have not been seen.
+	inflight int
+}
+
+// NewRateLimiter returns an empty limiter. Buckets are created lazily the
+// first time a token is seen.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: make(map[string]*bucket)}
+}
+
+// Wait reserves one request from the bucket of token, blocking until the
+// bucket resets if it is empty.
+func (l *RateLimiter) Wait(ctx context.Context, token string) error {
+	for {
+		l.mu.Lock()
+		b, ok := l.buckets[token]
+		if !ok {
+			// Unknown bucket: let the request through and learn the limits
+			// from its response headers.
+			l.mu.Unlock()
+			return nil
+		}
+		now := time.Now()
+		if !now.Before(b.reset) {
+			// The bucket has refilled. Let at least one request through to
+			// learn its new state, even if the last known limit was 0.
+			b.remaining = max(b.limit, 1)
+			b.reset = now.Add(rateLimitWindow)
+		}
+		if b.remaining > 0 {
+			b.remaining--
+			b.inflight++
+			l.mu.Unlock()
+			return nil
+		}
+		wait := max(time.Until(b.reset), rateLimitMinWait)
+		l.mu.Unlock()
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Update records the bucket state reported by a Helix response. Responses
+// without Ratelimit-* headers, e.g. errors from a proxy, only complete the
+// reservation made by Wait.
+func (l *RateLimiter) Update(token string, h http.Header) {
+	limit, errLimit := strconv.Atoi(h.Get("Ratelimit-Limit"))
+	remaining, errRemaining := strconv.Atoi(h.Get("Ratelimit-Remaining"))
+	reset, errReset := strconv.ParseInt(h.Get("Ratelimit-Reset"), 10, 64)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[token]
+	if ok && b.inflight > 0 {
+		b.inflight--
+	}
+	if errLimit != nil || errRemaining != nil || errReset != nil {
+		return
+	}
+	if !ok {
+		b = &bucket{}
+		l.buckets[token] = b
+	}
+	b.limit = limit
+	b.reset = time.Unix(reset, 0)
+	// Requests reserved concurrently have not been counted by the server yet.
+	b.remaining = remaining - b.inflight
+}
+
+// release returns a reservation made by Wait for a request that never got a
+// response.
+func (l *RateLimiter) release(token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[token]; ok && b.inflight > 0 {
+		b.inflight--
+		b.remaining++
+	}
+}
+
+// resetAfter returns how long until the bucket described by h refills, or
+// zero if the headers do not say.
+func resetAfter(h http.Header) time.Duration {
+	reset, err := strconv.ParseInt(h.Get("Ratelimit-Reset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	if d := time.Until(time.Unix(reset, 0)); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// backoff returns a fully jittered exponential delay for the given attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	if delay <= 0 {
+		delay = 500 * time.Millisecond
+	}
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable
+}
+
+// send performs req against base, honouring the token's rate limit bucket
+// and retrying retryable statuses according to the client's RetryPolicy.
+func (c *Client) send(base http.RoundTripper, req *http.Request, token string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(req.Context(), token); err != nil {
+			return nil, err
+		}
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				c.limiter.release(token)
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		resp, err := base.RoundTrip(req)
+		if err != nil {
+			c.limiter.release(token)
+			return nil, err
+		}
+		c.limiter.Update(token, resp.Header)
+		if !retryable(resp.StatusCode) || attempt >= c.Retry.MaxRetries {
+			return resp, nil
+		}
+		if req.Body != nil && req.GetBody == nil {
+			// Body was consumed and cannot be replayed.
+			return resp, nil
+		}
+
+		delay := c.Retry.backoff(attempt)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if d := resetAfter(resp.Header); d > 0 {
+				delay = d + time.Duration(rand.Int63n(int64(250*time.Millisecond)))
+			}
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
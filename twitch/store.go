@@ -0,0 +1,163 @@
+package twitch
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go-twitch/config"
+)
+
+// Keys under which the app and user tokens are stored.
+const (
+	AppTokenKey  = "app"
+	UserTokenKey = "user"
+)
+
+// TokenStore persists tokens by key. Implementations must be safe for
+// concurrent use.
+type TokenStore interface {
+	// Load returns the token stored under key; ok is false if there is none.
+	Load(key string) (t Token, ok bool, err error)
+	Save(key string, t Token) error
+	Delete(key string) error
+	// Keys lists every stored key.
+	Keys() ([]string, error)
+}
+
+// OpenTokenStore opens the store selected by cfg.TokenStore: "file" (the
+// default), "sqlite" or "memory". When encryption keys are configured the
+// store is wrapped in an EncryptedStore.
+func OpenTokenStore(cfg config.Config) (TokenStore, error) {
+	var store TokenStore
+	var err error
+	switch cfg.TokenStore {
+	case "", "file":
+		store, err = NewFileStore(cfg.TokenStorePath)
+	case "sqlite":
+		store, err = NewSQLiteStore(cfg.TokenStorePath)
+	case "memory":
+		store = NewMemoryStore()
+	default:
+		return nil, fmt.Errorf("unknown TOKEN_STORE %q (want file, sqlite or memory)", cfg.TokenStore)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys, err := LoadTokenKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return store, nil
+	}
+	return NewEncryptedStore(store, keys)
+}
+
+// persistTo returns a TokenManager persist func saving to key in store. An
+// empty token deletes the key.
+func persistTo(store TokenStore, key string) func(Token) error {
+	return func(t Token) error {
+		if t.AccessToken == "" {
+			return store.Delete(key)
+		}
+		return store.Save(key, t)
+	}
+}
+
+// loadToken returns the token under key, logging and ignoring read errors so
+// a broken store degrades to "not authorized" rather than a crash.
+func loadToken(store TokenStore, key string) Token {
+	t, _, err := store.Load(key)
+	if err != nil {
+		log.Printf("[TOKEN] failed to load %s token: %v", key, err)
+	}
+	return t
+}
+
+// MigrateEnvTokens moves tokens written to .env by earlier versions into
+// store. Keys already present in store are left alone. Migrated keys are
+// removed from .env so secrets no longer live next to configuration.
+func MigrateEnvTokens(store TokenStore) error {
+	type legacy struct {
+		key    string
+		prefix string
+		envs   []string
+	}
+	for _, l := range []legacy{
+		{AppTokenKey, "TWITCH_APP_ACCESS_TOKEN", []string{"TWITCH_APP_ACCESS_TOKEN", "TWITCH_APP_ACCESS_TOKEN_EXPIRES_AT"}},
+		{UserTokenKey, "TWITCH_USER_ACCESS_TOKEN", []string{"TWITCH_USER_ACCESS_TOKEN", "TWITCH_USER_ACCESS_TOKEN_EXPIRES_AT", "TWITCH_USER_REFRESH_TOKEN", "TWITCH_USER_SCOPES"}},
+	} {
+		access := os.Getenv(l.prefix)
+		if access == "" {
+			continue
+		}
+		if _, ok, err := store.Load(l.key); err != nil {
+			return err
+		} else if !ok {
+			t := Token{AccessToken: access}
+			if expires, err := time.Parse(time.RFC3339, os.Getenv(l.prefix+"_EXPIRES_AT")); err == nil {
+				t.ExpiresAt = expires
+			}
+			if l.key == UserTokenKey {
+				t.RefreshToken = os.Getenv("TWITCH_USER_REFRESH_TOKEN")
+				t.Scopes = strings.Fields(os.Getenv("TWITCH_USER_SCOPES"))
+			}
+			if err := store.Save(l.key, t); err != nil {
+				return fmt.Errorf("failed to migrate %s token: %w", l.key, err)
+			}
+			log.Printf("[TOKEN] migrated %s token from .env to the token store", l.key)
+		}
+		if err := RemoveEnvKeys(l.envs...); err != nil {
+			return fmt.Errorf("failed to remove migrated %s token from .env: %w", l.key, err)
+		}
+		for _, env := range l.envs {
+			os.Unsetenv(env)
+		}
+	}
+	return nil
+}
+
+// MemoryStore keeps tokens in process memory only.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tokens map[string]Token
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]Token)}
+}
+
+func (s *MemoryStore) Load(key string) (Token, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tokens[key]
+	return t, ok, nil
+}
+
+func (s *MemoryStore) Save(key string, t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = t
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, key)
+	return nil
+}
+
+func (s *MemoryStore) Keys() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.tokens))
+	for k := range s.tokens {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
@@ -0,0 +1,227 @@
+package twitch
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go-twitch/config"
+)
+
+// encPrefix marks an encrypted token field: enc:v1:<key id>:<base64 nonce+ciphertext>.
+const encPrefix = "enc:v1:"
+
+// ErrNoTokenKey is returned when an encrypted token was written with a key
+// that is not configured.
+var ErrNoTokenKey = errors.New("token was encrypted with an unknown key")
+
+// TokenKey is an AES-256 key used to encrypt tokens at rest.
+type TokenKey struct {
+	ID  string
+	key []byte
+}
+
+// ParseTokenKey decodes a base64 encoded 32-byte key.
+func ParseTokenKey(s string) (TokenKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return TokenKey{}, fmt.Errorf("token key is not valid base64: %w", err)
+	}
+	if len(raw) != 32 {
+		return TokenKey{}, fmt.Errorf("token key must be 32 bytes, got %d", len(raw))
+	}
+	sum := sha256.Sum256(raw)
+	return TokenKey{ID: hex.EncodeToString(sum[:4]), key: raw}, nil
+}
+
+// GenerateTokenKey returns a new random key, base64 encoded.
+func GenerateTokenKey() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// LoadTokenKeys returns the configured encryption keys, current key first.
+// The current key comes from TOKEN_ENCRYPTION_KEY or, failing that, the first
+// line of TOKEN_ENCRYPTION_KEY_FILE; further lines of the file and
+// TOKEN_ENCRYPTION_OLD_KEYS hold retired keys that are only used to decrypt.
+// No keys means encryption is disabled.
+func LoadTokenKeys(cfg config.Config) ([]TokenKey, error) {
+	var encoded []string
+	if cfg.TokenEncryptionKey != "" {
+		encoded = append(encoded, cfg.TokenEncryptionKey)
+	}
+	if cfg.TokenEncryptionKeyFile != "" {
+		data, err := os.ReadFile(cfg.TokenEncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read token key file: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				encoded = append(encoded, line)
+			}
+		}
+	}
+	for _, k := range strings.Split(cfg.TokenEncryptionOldKeys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			encoded = append(encoded, k)
+		}
+	}
+
+	var keys []TokenKey
+	seen := make(map[string]bool)
+	for _, e := range encoded {
+		k, err := ParseTokenKey(e)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[k.ID] {
+			seen[k.ID] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// EncryptedStore wraps a TokenStore and encrypts access and refresh tokens
+// with AES-GCM before they reach it. Scopes and expiry stay readable.
+// Tokens are always written with the first key; the others are tried by ID
+// when reading, so keys can be rotated and old tokens re-encrypted with
+// Rekey. Plaintext tokens are read as-is, which lets encryption be turned on
+// for an existing store.
+type EncryptedStore struct {
+	store TokenStore
+	keys  []TokenKey
+}
+
+func NewEncryptedStore(store TokenStore, keys []TokenKey) (*EncryptedStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("encrypted token store needs at least one key")
+	}
+	return &EncryptedStore{store: store, keys: keys}, nil
+}
+
+func (s *EncryptedStore) Load(key string) (Token, bool, error) {
+	t, ok, err := s.store.Load(key)
+	if err != nil || !ok {
+		return t, ok, err
+	}
+	if t.AccessToken, err = s.decrypt(key, "access", t.AccessToken); err != nil {
+		return Token{}, false, err
+	}
+	if t.RefreshToken, err = s.decrypt(key, "refresh", t.RefreshToken); err != nil {
+		return Token{}, false, err
+	}
+	return t, true, nil
+}
+
+func (s *EncryptedStore) Save(key string, t Token) error {
+	var err error
+	if t.AccessToken, err = s.encrypt(key, "access", t.AccessToken); err != nil {
+		return err
+	}
+	if t.RefreshToken, err = s.encrypt(key, "refresh", t.RefreshToken); err != nil {
+		return err
+	}
+	return s.store.Save(key, t)
+}
+
+func (s *EncryptedStore) Delete(key string) error {
+	return s.store.Delete(key)
+}
+
+func (s *EncryptedStore) Keys() ([]string, error) {
+	return s.store.Keys()
+}
+
+// Rekey re-encrypts every stored token with the current key, including
+// tokens still stored in plaintext, and returns how many were rewritten.
+func (s *EncryptedStore) Rekey() (int, error) {
+	keys, err := s.store.Keys()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range keys {
+		t, ok, err := s.Load(key)
+		if err != nil {
+			return n, fmt.Errorf("failed to decrypt %s token: %w", key, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := s.Save(key, t); err != nil {
+			return n, fmt.Errorf("failed to re-encrypt %s token: %w", key, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// encrypt seals value with the current key. The store key and field name are
+// bound as additional data so ciphertexts cannot be swapped between entries.
+func (s *EncryptedStore) encrypt(key, field, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	k := s.keys[0]
+	gcm, err := newGCM(k.key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(key+"/"+field))
+	return encPrefix + k.ID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *EncryptedStore) decrypt(key, field, value string) (string, error) {
+	if !strings.HasPrefix(value, encPrefix) {
+		return value, nil
+	}
+	id, payload, ok := strings.Cut(strings.TrimPrefix(value, encPrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted %s token", key)
+	}
+	for _, k := range s.keys {
+		if k.ID != id {
+			continue
+		}
+		sealed, err := base64.RawStdEncoding.DecodeString(payload)
+		if err != nil {
+			return "", fmt.Errorf("malformed encrypted %s token: %w", key, err)
+		}
+		gcm, err := newGCM(k.key)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < gcm.NonceSize() {
+			return "", fmt.Errorf("malformed encrypted %s token", key)
+		}
+		plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(key+"/"+field))
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt %s token: %w", key, err)
+		}
+		return string(plain), nil
+	}
+	return "", fmt.Errorf("%w (key id %s)", ErrNoTokenKey, id)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,137 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps tokens in a JSON file readable only by the owner. Every
+// write goes to a temporary file that is fsynced and renamed over the
+// original, so a crash never leaves a half-written store behind.
+type FileStore struct {
+	path string
+
+	mu     sync.Mutex
+	tokens map[string]Token
+}
+
+// NewFileStore opens the store at path, creating it on first write.
+func NewFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		path = "tokens.json"
+	}
+	s := &FileStore{path: path, tokens: make(map[string]Token)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token store: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.tokens); err != nil {
+			return nil, fmt.Errorf("failed to parse token store %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) Load(key string) (Token, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[key]
+	return t, ok, nil
+}
+
+func (s *FileStore) Save(key string, t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, had := s.tokens[key]
+	s.tokens[key] = t
+	if err := s.write(); err != nil {
+		if had {
+			s.tokens[key] = prev
+		} else {
+			delete(s.tokens, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, had := s.tokens[key]
+	if !had {
+		return nil
+	}
+	delete(s.tokens, key)
+	if err := s.write(); err != nil {
+		s.tokens[key] = prev
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Keys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.tokens))
+	for k := range s.tokens {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// write atomically replaces the file with the current tokens. Callers hold mu.
+func (s *FileStore) write() error {
+	data, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data, readable only by the owner. The
+// data goes to a temporary file that is fsynced and renamed over path, so a
+// crash never leaves a half-written file behind.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Persist the rename itself.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
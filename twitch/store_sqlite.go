@@ -0,0 +1,94 @@
+package twitch
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps tokens in a SQLite database, one JSON row per key.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (and if needed creates) the database at path.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if path == "" {
+		path = "tokens.db"
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token database: %w", err)
+	}
+	// SQLite allows a single writer; serialise access through one connection.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS tokens (
+		key        TEXT PRIMARY KEY,
+		data       TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create token table: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Load(key string) (Token, bool, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM tokens WHERE key = ?`, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return Token{}, false, nil
+	}
+	if err != nil {
+		return Token{}, false, fmt.Errorf("failed to load token: %w", err)
+	}
+	var t Token
+	if err := json.Unmarshal([]byte(data), &t); err != nil {
+		return Token{}, false, fmt.Errorf("failed to decode token: %w", err)
+	}
+	return t, true, nil
+}
+
+func (s *SQLiteStore) Save(key string, t Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO tokens (key, data, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`, key, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Delete(key string) error {
+	if _, err := s.db.Exec(`DELETE FROM tokens WHERE key = ?`, key); err != nil {
+		return fmt.Errorf("failed to delete token: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Keys() ([]string, error) {
+	rows, err := s.db.Query(`SELECT key FROM tokens`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,238 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// tokenRefreshMargin is how long before expiry a token is renewed.
+	tokenRefreshMargin = 10 * time.Minute
+	// tokenRetryMin and tokenRetryMax bound the backoff after a failed refresh.
+	tokenRetryMin = 30 * time.Second
+	tokenRetryMax = 5 * time.Minute
+)
+
+// TokenSource supplies the access token to send with a Helix request.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// TokenFunc adapts a plain function to a TokenSource.
+type TokenFunc func() (string, error)
+
+func (f TokenFunc) Token() (string, error) { return f() }
+
+// StaticToken is a TokenSource that always returns the same token.
+type StaticToken string
+
+func (t StaticToken) Token() (string, error) {
+	if t == "" {
+		return "", fmt.Errorf("no access token configured")
+	}
+	return string(t), nil
+}
+
+// Token is an OAuth access token and its expiry. User tokens also record
+// the account they belong to and its roles; app tokens carry none of that,
+// nor a refresh token or scopes.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Scopes       []string  `json:"scopes,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	UserID       string    `json:"user_id,omitempty"`
+	Login        string    `json:"login,omitempty"`
+	Roles        []Role    `json:"roles,omitempty"`
+}
+
+// RefreshFunc obtains a new token to replace current.
+type RefreshFunc func(ctx context.Context, current Token) (Token, error)
+
+// TokenManager holds an access token, renews it in the background before it
+// expires and refreshes it on demand when Twitch rejects it. It implements
+// TokenSource and is safe for concurrent use.
+type TokenManager struct {
+	name    string
+	refresh RefreshFunc
+	persist func(Token) error
+
+	mu        sync.RWMutex
+	token     Token
+	listeners []func(Token)
+	// invalid is set when Twitch rejected the token and it could not be
+	// renewed; validation holds the latest validate endpoint result.
+	invalid    bool
+	validation *Validation
+
+	// refreshMu serialises refreshes so concurrent 401s trigger one renewal.
+	refreshMu sync.Mutex
+	// wake interrupts Run's sleep after an out-of-band refresh.
+	wake chan struct{}
+}
+
+// NewTokenManager returns a manager seeded with initial. refresh is called to
+// renew the token; persist, if non-nil, is called after every renewal.
+func NewTokenManager(name string, initial Token, refresh RefreshFunc, persist func(Token) error) *TokenManager {
+	return &TokenManager{
+		name:    name,
+		refresh: refresh,
+		persist: persist,
+		token:   initial,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Token returns the current access token.
+func (m *TokenManager) Token() (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.token.AccessToken == "" {
+		return "", fmt.Errorf("%s token not available", m.name)
+	}
+	if m.invalid {
+		return "", fmt.Errorf("%s token was rejected by Twitch", m.name)
+	}
+	return m.token.AccessToken, nil
+}
+
+// Valid reports whether the token is present and has not been rejected.
+func (m *TokenManager) Valid() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.token.AccessToken != "" && !m.invalid && time.Now().Before(m.token.ExpiresAt)
+}
+
+// Current returns a copy of the current token.
+func (m *TokenManager) Current() Token {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.token
+}
+
+// Refresh renews the token unconditionally.
+func (m *TokenManager) Refresh(ctx context.Context) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	return m.doRefresh(ctx)
+}
+
+// Invalidate reports that rejected was refused by Twitch. If it is still the
+// current token, it is refreshed; if another caller already replaced it, the
+// newer token is kept. The returned token is the one to retry with.
+func (m *TokenManager) Invalidate(ctx context.Context, rejected string) (string, error) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	if current := m.Current().AccessToken; current != rejected && current != "" {
+		return current, nil
+	}
+	log.Printf("[TOKEN] %s token rejected by Twitch, refreshing", m.name)
+	if err := m.doRefresh(ctx); err != nil {
+		m.mu.Lock()
+		m.invalid = true
+		m.mu.Unlock()
+		return "", err
+	}
+	return m.Token()
+}
+
+func (m *TokenManager) doRefresh(ctx context.Context) error {
+	token, err := m.refresh(ctx, m.Current())
+	if err != nil {
+		return fmt.Errorf("failed to refresh %s token: %w", m.name, err)
+	}
+	m.store(token)
+	return nil
+}
+
+// Set replaces the token, e.g. after a fresh OAuth authorization.
+func (m *TokenManager) Set(token Token) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	m.store(token)
+}
+
+// Clear forgets the token, e.g. after it was revoked. Listeners receive an
+// empty Token.
+func (m *TokenManager) Clear() {
+	m.Set(Token{})
+}
+
+// OnChange registers fn to be called with every new token.
+func (m *TokenManager) OnChange(fn func(Token)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+func (m *TokenManager) store(token Token) {
+	m.mu.Lock()
+	m.token = token
+	m.invalid = false
+	m.validation = nil
+	listeners := append([]func(Token){}, m.listeners...)
+	m.mu.Unlock()
+
+	if m.persist != nil {
+		if err := m.persist(token); err != nil {
+			log.Printf("[TOKEN] failed to persist %s token: %v", m.name, err)
+		}
+	}
+	for _, fn := range listeners {
+		fn(token)
+	}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// EnsureValid refreshes the token if it is missing or about to expire.
+func (m *TokenManager) EnsureValid(ctx context.Context) error {
+	t := m.Current()
+	if t.AccessToken != "" && time.Until(t.ExpiresAt) > tokenRefreshMargin {
+		return nil
+	}
+	return m.Refresh(ctx)
+}
+
+// Run keeps the token fresh until ctx is cancelled, renewing it
+// tokenRefreshMargin before expiry and backing off after failures.
+func (m *TokenManager) Run(ctx context.Context) {
+	retry := tokenRetryMin
+	for {
+		if m.Current().AccessToken == "" {
+			// Nothing to renew until a token is Set.
+			select {
+			case <-ctx.Done():
+				return
+			case <-m.wake:
+				continue
+			}
+		}
+		wait := time.Until(m.Current().ExpiresAt) - tokenRefreshMargin
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-m.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		if err := m.EnsureValid(ctx); err != nil {
+			log.Printf("[TOKEN] %v; retrying in %s", err, retry)
+			if sleep(ctx, retry) != nil {
+				return
+			}
+			retry = min(retry*2, tokenRetryMax)
+			continue
+		}
+		retry = tokenRetryMin
+	}
+}
@@ -0,0 +1,185 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	oauthValidateURL = "https://id.twitch.tv/oauth2/validate"
+	// validateInterval is how often Twitch requires apps to validate tokens.
+	validateInterval = time.Hour
+	// validateExpiryDrift is how far the expiry Twitch reports may be off
+	// the recorded one before the token is updated.
+	validateExpiryDrift = time.Minute
+)
+
+// ErrTokenInvalid is returned by ValidateToken when Twitch no longer accepts
+// the token.
+var ErrTokenInvalid = errors.New("token is invalid")
+
+// Validation is the result of checking a token against the validate endpoint.
+type Validation struct {
+	Valid     bool      `json:"valid"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Login     string    `json:"login,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ValidateToken checks token against id.twitch.tv/oauth2/validate.
+func ValidateToken(ctx context.Context, token string) (*Validation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oauthValidateURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create validate request: %w", err)
+	}
+	req.Header.Set("Authorization", "OAuth "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to validate token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read validate response: %w", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrTokenInvalid
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to validate token: status %d, body %s", resp.StatusCode, string(body))
+	}
+
+	var v struct {
+		ClientID  string   `json:"client_id"`
+		Login     string   `json:"login"`
+		UserID    string   `json:"user_id"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int      `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, fmt.Errorf("failed to parse validate response: %w", err)
+	}
+	now := time.Now()
+	return &Validation{
+		Valid:     true,
+		ClientID:  v.ClientID,
+		Login:     v.Login,
+		UserID:    v.UserID,
+		Scopes:    v.Scopes,
+		ExpiresAt: now.Add(time.Duration(v.ExpiresIn) * time.Second),
+		CheckedAt: now,
+	}, nil
+}
+
+// Validate checks the current token with Twitch and records the result. A
+// rejected token is marked invalid and refreshed; if the refresh fails too,
+// Token reports an error until a new token is Set.
+func (m *TokenManager) Validate(ctx context.Context) error {
+	return m.validate(ctx, true)
+}
+
+func (m *TokenManager) validate(ctx context.Context, refresh bool) error {
+	token := m.Current().AccessToken
+	if token == "" {
+		return nil
+	}
+	v, err := ValidateToken(ctx, token)
+	if errors.Is(err, ErrTokenInvalid) {
+		m.mu.Lock()
+		if m.token.AccessToken == token {
+			m.invalid = true
+			m.validation = &Validation{CheckedAt: time.Now(), Error: err.Error()}
+		}
+		m.mu.Unlock()
+		log.Printf("[TOKEN] %s token failed validation", m.name)
+		if !refresh {
+			return fmt.Errorf("%s token invalid, re-authorization required", m.name)
+		}
+		if _, err := m.Invalidate(ctx, token); err != nil {
+			return fmt.Errorf("%s token invalid, re-authorization required: %w", m.name, err)
+		}
+		return m.validate(ctx, false)
+	}
+	if err != nil {
+		// Network or server trouble says nothing about the token; keep the
+		// previous verdict.
+		return err
+	}
+
+	m.mu.Lock()
+	if m.token.AccessToken != token {
+		// Replaced while we were validating; the next run checks the new one.
+		m.mu.Unlock()
+		return nil
+	}
+	m.validation = v
+	t := m.token
+	m.mu.Unlock()
+	drift := t.ExpiresAt.Sub(v.ExpiresAt).Abs()
+	if drift < validateExpiryDrift && slices.Equal(t.Scopes, v.Scopes) {
+		return nil
+	}
+
+	// Store what Twitch reports like Set does, so it is persisted and Run
+	// reschedules the refresh.
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	if t = m.Current(); t.AccessToken != token {
+		return nil
+	}
+	t.ExpiresAt, t.Scopes = v.ExpiresAt, v.Scopes
+	m.store(t)
+	m.mu.Lock()
+	m.validation = v
+	m.mu.Unlock()
+	return nil
+}
+
+// Validation returns the result of the most recent validation, or nil if the
+// current token has not been validated yet.
+func (m *TokenManager) Validation() *Validation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.validation == nil {
+		return nil
+	}
+	v := *m.validation
+	return &v
+}
+
+// RunValidation validates the token immediately, whenever it changes and
+// then hourly, as Twitch requires, until ctx is cancelled.
+func (m *TokenManager) RunValidation(ctx context.Context) {
+	changed := make(chan struct{}, 1)
+	m.OnChange(func(Token) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	ticker := time.NewTicker(validateInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.Validate(ctx); err != nil {
+			log.Printf("[TOKEN] validation: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+	}
+}